@@ -0,0 +1,286 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryMinBackoff = 10 * time.Millisecond
+	defaultRetryMaxBackoff = time.Second
+
+	defaultBreakerFailures    = 5
+	defaultBreakerOpenTimeout = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned for requests to a peer whose circuit breaker
+// is open. They fail without being sent.
+var ErrCircuitOpen = errors.New("groupcache: circuit breaker open")
+
+// RetryOptions configure the retries of requests to peers which fail to
+// reach the peer or time out. Errors returned by the peer's groups are not
+// retried. Get, GetMany, Remove and Clear are retried; Set is not, since a
+// late retry could overwrite a newer value.
+type RetryOptions struct {
+	// MaxAttempts is the number of attempts of each request, including
+	// the first one. If zero, it defaults to 3.
+	MaxAttempts int
+
+	// MinBackoff is the time waited before the first retry. It doubles
+	// with each retry, up to MaxBackoff, and is jittered by up to half.
+	// If zero, they default to 10 milliseconds and 1 second.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// backoff returns the time to wait before the n-th retry, n >= 1.
+func (o *RetryOptions) backoff(n int) time.Duration {
+	d := o.MinBackoff
+	for i := 1; i < n && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// BreakerOptions configure the circuit breaker of each peer. A breaker
+// opens after consecutive failures to reach its peer, and requests to the
+// peer then fail with ErrCircuitOpen. Once OpenTimeout has passed, one
+// request is let through: the breaker closes if it succeeds and opens
+// again otherwise.
+type BreakerOptions struct {
+	// MaxFailures is the number of consecutive failed attempts after
+	// which the breaker opens. If zero, it defaults to 5.
+	MaxFailures int
+
+	// OpenTimeout is the time the breaker stays open before letting a
+	// trial request through. If zero, it defaults to 10 seconds.
+	OpenTimeout time.Duration
+
+	// Timer tells the time. If nil, it defaults to timer.Default.
+	Timer timer.Timer
+}
+
+// BreakerState is the state of the circuit breaker of a peer.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen lets a trial request through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStats are the statistics of the requests made by a pool to a
+// peer, as returned by HTTPPool.BreakerStats.
+type BreakerStats struct {
+	State    BreakerState
+	Failures int64 // consecutive failed attempts
+	Opens    int64 // times the breaker opened
+	Rejected int64 // requests failed with ErrCircuitOpen
+	Retries  int64 // attempts after the first of a request
+}
+
+// breaker holds the circuit breaker and retry counters of a peer. It is
+// kept by the pool for as long as the peer is in the pool.
+type breaker struct {
+	opts BreakerOptions // MaxFailures is zero if the breaker never opens
+
+	mu       sync.Mutex
+	stats    BreakerStats
+	openedAt int64
+	trial    bool // a trial request is in flight in BreakerHalfOpen
+}
+
+func newBreaker(o *BreakerOptions) *breaker {
+	b := &breaker{}
+	if o == nil {
+		return b
+	}
+	b.opts = *o
+	if b.opts.MaxFailures <= 0 {
+		b.opts.MaxFailures = defaultBreakerFailures
+	}
+	if b.opts.OpenTimeout <= 0 {
+		b.opts.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if b.opts.Timer == nil {
+		b.opts.Timer = timer.Default{}
+	}
+	return b
+}
+
+// allow reports whether an attempt may be sent to the peer.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stats.State {
+	case BreakerOpen:
+		if b.opts.Timer.Now() < b.openedAt+int64(b.opts.OpenTimeout) {
+			b.stats.Rejected++
+			return false
+		}
+		b.stats.State = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			b.stats.Rejected++
+			return false
+		}
+	default:
+		return true
+	}
+	b.trial = true
+	return true
+}
+
+// record records the outcome of an attempt let through by allow.
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !failed {
+		b.stats.Failures = 0
+		b.stats.State = BreakerClosed
+		return
+	}
+	b.stats.Failures++
+	if b.opts.MaxFailures == 0 {
+		return
+	}
+	if b.stats.State == BreakerHalfOpen || b.stats.Failures >= int64(b.opts.MaxFailures) {
+		if b.stats.State != BreakerOpen {
+			b.stats.Opens++
+		}
+		b.stats.State = BreakerOpen
+		b.openedAt = b.opts.Timer.Now()
+	}
+}
+
+// release ends an attempt let through by allow without an outcome, as
+// when the caller gave up on it, leaving the state and failures as they
+// were. An abandoned trial leaves the breaker open, and the next request
+// is a trial.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.trial {
+		b.trial = false
+		b.stats.State = BreakerOpen
+	}
+}
+
+func (b *breaker) retried() {
+	b.mu.Lock()
+	b.stats.Retries++
+	b.mu.Unlock()
+}
+
+func (b *breaker) snapshot() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// do calls fn until it succeeds, as allowed by the breaker and the retry
+// options, each call limited by timeout if not zero. Only idempotent
+// requests are retried.
+func (h *httpGetter) do(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+	if h.breaker == nil {
+		return h.attempt(ctx, fn)
+	}
+	attempts := 1
+	if idempotent && h.retry != nil {
+		attempts = h.retry.MaxAttempts
+	}
+	var err error
+	for n := 0; n < attempts; n++ {
+		if n > 0 {
+			if serr := sleepCtx(ctx, h.retry.backoff(n)); serr != nil {
+				return err
+			}
+			h.breaker.retried()
+		}
+		if !h.breaker.allow() {
+			if err == nil {
+				err = ErrCircuitOpen
+			}
+			return err
+		}
+		err = h.attempt(ctx, fn)
+		if ctx.Err() != nil {
+			// the caller gave up, which says nothing about the peer
+			h.breaker.release()
+			return err
+		}
+		failed := unreachedPeer(ctx, err)
+		h.breaker.record(failed)
+		if !failed {
+			return err
+		}
+	}
+	return err
+}
+
+func (h *httpGetter) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	actx := ctx
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	err := fn(actx)
+	// The timeout of the attempt counts against the peer, but not the
+	// deadline of the caller.
+	var unreached error
+	if unreachedPeer(ctx, err) {
+		unreached = err
+	}
+	h.health.observe(ctx, h.peer, unreached)
+	return err
+}
+
+// unreachedPeer reports whether err means that a request failed to reach
+// the peer or to get its answer in time, as opposed to an error returned
+// by the peer or the caller giving up.
+func unreachedPeer(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var pe *PeerError
+	var se *statusError
+	return !errors.As(err, &pe) && !errors.As(err, &se)
+}
+
+// sleepCtx waits for d, or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
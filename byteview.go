@@ -34,6 +34,11 @@ type ByteView struct {
 	b []byte
 	s string
 	e int64
+	r int64 // when the cached value becomes stale, zero if never
+
+	// If err is non-nil, the view holds a cached error in place of a
+	// value, see CacheableError.
+	err error
 }
 
 // Returns the expire time associated with this view
@@ -0,0 +1,146 @@
+package consistenthash
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// boundedLoadSlotBits is the log2 of the number of slots of a BoundedLoad.
+const boundedLoadSlotBits = 14
+
+// DefaultLoadFactor is the load factor of a BoundedLoad created with a
+// factor of at most 1.
+const DefaultLoadFactor = 1.25
+
+// BoundedLoad places keys with Consistent Hashing with Bounded Loads, from
+// the paper of the same name by Mirrokni, Thorup and Zadimoghaddam, applied
+// to slots of the key space. The key space is split into slots which are
+// placed in turn on the first item following them on a ring which holds
+// fewer than c times its share of the slots, by weight. No item gets more
+// than about c times its share of the keys, which a ring alone does not
+// guarantee, while most slots stay in place when items change.
+//
+// Since slots are placed in the same way on every peer, all the peers
+// agree on the placement of keys.
+type BoundedLoad struct {
+	ring   *Map
+	c      float64
+	items  []string
+	owners []string // item of each slot, not modified once built
+}
+
+// NewBoundedLoad creates a BoundedLoad placement on a ring with the given
+// number of replicas of each item, hashing keys and items with fn, or
+// fnv1.HashBytes64 if fn is nil. Items hold at most c times their share
+// of the slots, or DefaultLoadFactor times if c is at most 1.
+func NewBoundedLoad(replicas int, fn Hash, c float64) *BoundedLoad {
+	if c <= 1 {
+		c = DefaultLoadFactor
+	}
+	return &BoundedLoad{ring: New(replicas, fn), c: c}
+}
+
+func (b *BoundedLoad) Add(items ...string) {
+	for _, item := range items {
+		if !contains(b.items, item) {
+			b.items = append(b.items, item)
+			b.ring.Add(item)
+		}
+	}
+	b.place()
+}
+
+func (b *BoundedLoad) AddWeighted(item string, weight int) {
+	if !contains(b.items, item) {
+		b.items = append(b.items, item)
+	}
+	b.ring.AddWeighted(item, weight)
+	b.place()
+}
+
+func (b *BoundedLoad) Remove(items ...string) {
+	b.items = removeItems(b.items, items)
+	b.ring.Remove(items...)
+	b.place()
+}
+
+// place places every slot on an item. Items hold at most c times their
+// share of the slots, by weight.
+func (b *BoundedLoad) place() {
+	if len(b.items) == 0 {
+		b.owners = nil
+		return
+	}
+	const slots = 1 << boundedLoadSlotBits
+	total := 0
+	for _, item := range b.items {
+		total += b.ring.weight(item)
+	}
+	capacity := make(map[string]int, len(b.items))
+	for _, item := range b.items {
+		capacity[item] = int(math.Ceil(b.c * slots * float64(b.ring.weight(item)) / float64(total)))
+	}
+	loads := make(map[string]int, len(b.items))
+	owners := make([]string, slots)
+	keys := b.ring.keys
+	for s := range owners {
+		pos := slotPosition(s)
+		idx := sort.Search(len(keys), func(i int) bool { return keys[i] >= pos })
+		for i := 0; ; i++ {
+			item := b.ring.hashMap[keys[(idx+i)%len(keys)]]
+			if loads[item] < capacity[item] {
+				owners[s] = item
+				loads[item]++
+				break
+			}
+		}
+	}
+	b.owners = owners
+}
+
+// slotPosition returns the position of slot s on the ring. Slots are
+// spread over the whole range of int, as are the positions of the items.
+func slotPosition(s int) int {
+	return int(uint(s) << (bits.UintSize - boundedLoadSlotBits))
+}
+
+func (b *BoundedLoad) slot(key string) int {
+	return int(mix(b.ring.hash([]byte(key))) >> (64 - boundedLoadSlotBits))
+}
+
+func (b *BoundedLoad) IsEmpty() bool {
+	return len(b.items) == 0
+}
+
+func (b *BoundedLoad) Get(key string) string {
+	if b.IsEmpty() {
+		return ""
+	}
+	return b.owners[b.slot(key)]
+}
+
+// GetN returns the item of key followed by the items which follow its
+// slot on the ring.
+func (b *BoundedLoad) GetN(key string, n int) []string {
+	if b.IsEmpty() || n <= 0 {
+		return nil
+	}
+	s := b.slot(key)
+	items := []string{b.owners[s]}
+	for _, item := range b.ring.getAt(slotPosition(s), n+1) {
+		if len(items) < n && item != items[0] {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (b *BoundedLoad) Clone() Placement {
+	return &BoundedLoad{
+		ring:   b.ring.clone(),
+		c:      b.c,
+		items:  append([]string(nil), b.items...),
+		owners: b.owners,
+	}
+}
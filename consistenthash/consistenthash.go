@@ -33,6 +33,7 @@ type Map struct {
 	replicas int
 	keys     []int // Sorted
 	hashMap  map[int]string
+	weights  map[string]int // of the keys added with a weight other than 1
 }
 
 func New(replicas int, fn Hash) *Map {
@@ -55,15 +56,105 @@ func (m *Map) IsEmpty() bool {
 // Adds some keys to the hash.
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(fmt.Sprintf("%x", md5.Sum([]byte(strconv.Itoa(i)+key))))))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+		m.add(key, m.replicas)
+	}
+	sort.Ints(m.keys)
+}
+
+// AddWeighted adds key to the hash with weight times the replicas of a
+// key added with Add, so that it gets a share of the items proportional
+// to its weight. If key is in the hash, its weight is changed: the
+// replicas it keeps stay in place. A weight below 1 counts as 1.
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.Remove(key)
+	if weight == 1 {
+		delete(m.weights, key)
+	} else {
+		if m.weights == nil {
+			m.weights = make(map[string]int)
 		}
+		m.weights[key] = weight
 	}
+	m.add(key, weight*m.replicas)
 	sort.Ints(m.keys)
 }
 
+func (m *Map) add(key string, replicas int) {
+	for i := 0; i < replicas; i++ {
+		hash := m.replicaHash(key, i)
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = key
+	}
+}
+
+// weight returns the weight of key, 1 unless it was added with another
+// weight by AddWeighted.
+func (m *Map) weight(key string) int {
+	if w, ok := m.weights[key]; ok {
+		return w
+	}
+	return 1
+}
+
+// Remove removes some keys from the hash. The other keys keep their
+// place, so only the items which hashed to the removed keys move.
+func (m *Map) Remove(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		for i := 0; i < m.weight(key)*m.replicas; i++ {
+			hash := m.replicaHash(key, i)
+			if m.hashMap[hash] == key {
+				delete(m.hashMap, hash)
+				removed = true
+			}
+		}
+		delete(m.weights, key)
+	}
+	if !removed {
+		return
+	}
+	kept := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			kept = append(kept, hash)
+		}
+	}
+	m.keys = kept
+}
+
+// Clone returns a copy of the hash, which can be modified while the
+// original is read.
+func (m *Map) Clone() Placement {
+	return m.clone()
+}
+
+func (m *Map) clone() *Map {
+	c := &Map{
+		hash:     m.hash,
+		replicas: m.replicas,
+		keys:     make([]int, len(m.keys)),
+		hashMap:  make(map[int]string, len(m.hashMap)),
+	}
+	copy(c.keys, m.keys)
+	for hash, key := range m.hashMap {
+		c.hashMap[hash] = key
+	}
+	if len(m.weights) > 0 {
+		c.weights = make(map[string]int, len(m.weights))
+		for key, w := range m.weights {
+			c.weights[key] = w
+		}
+	}
+	return c
+}
+
+func (m *Map) replicaHash(key string, i int) int {
+	return int(m.hash([]byte(fmt.Sprintf("%x", md5.Sum([]byte(strconv.Itoa(i)+key))))))
+}
+
 // Gets the closest item in the hash to the provided key.
 func (m *Map) Get(key string) string {
 	if m.IsEmpty() {
@@ -82,3 +173,37 @@ func (m *Map) Get(key string) string {
 
 	return m.hashMap[m.keys[idx]]
 }
+
+// GetN returns up to n distinct items which follow the provided key in
+// the hash, the closest first. The first item is the one returned by Get.
+// Fewer than n items are returned if the hash holds fewer.
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+
+	return m.getAt(int(m.hash([]byte(key))), n)
+}
+
+// getAt returns up to n distinct items which follow hash on the ring.
+func (m *Map) getAt(hash int, n int) []string {
+	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+
+	items := make([]string, 0, n)
+	for i := 0; i < len(m.keys) && len(items) < n; i++ {
+		item := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(items []string, item string) bool {
+	for _, it := range items {
+		if it == item {
+			return true
+		}
+	}
+	return false
+}
@@ -119,6 +119,73 @@ func TestDistribution(t *testing.T) {
 	}
 }
 
+func TestGetN(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c", "d")
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		got := hash.GetN(key, 3)
+		if len(got) != 3 {
+			t.Fatalf("GetN(%q, 3) = %v, want 3 items", key, got)
+		}
+		if got[0] != hash.Get(key) {
+			t.Errorf("GetN(%q, 3)[0] = %q, want %q", key, got[0], hash.Get(key))
+		}
+		if got[0] == got[1] || got[0] == got[2] || got[1] == got[2] {
+			t.Errorf("GetN(%q, 3) = %v, want distinct items", key, got)
+		}
+		// Prefixes are stable
+		if two := hash.GetN(key, 2); two[0] != got[0] || two[1] != got[1] {
+			t.Errorf("GetN(%q, 2) = %v, not a prefix of %v", key, two, got)
+		}
+	}
+
+	if got := hash.GetN("key", 10); len(got) != 4 {
+		t.Errorf("GetN with n above the number of items = %v, want 4 items", got)
+	}
+	if got := New(50, nil).GetN("key", 3); got != nil {
+		t.Errorf("GetN on an empty hash = %v, want nil", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c", "d")
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		before[key] = hash.Get(key)
+	}
+
+	clone := hash.Clone()
+	clone.Remove("c", "unknown")
+
+	// Only the keys of the removed item move
+	for key, owner := range before {
+		if got := hash.Get(key); got != owner {
+			t.Fatalf("Get(%q) on the original = %q, want %q", key, got, owner)
+		}
+		got := clone.Get(key)
+		if got == "c" || (owner != "c" && got != owner) {
+			t.Errorf("Get(%q) after Remove(c) = %q, was %q", key, got, owner)
+		}
+	}
+
+	// Removing and adding back gives the same hash as adding once
+	clone.Add("c")
+	for key, owner := range before {
+		if got := clone.Get(key); got != owner {
+			t.Errorf("Get(%q) after adding c back = %q, want %q", key, got, owner)
+		}
+	}
+
+	clone.Remove("a", "b", "c", "d")
+	if !clone.IsEmpty() {
+		t.Error("expected the hash to be empty after removing every item")
+	}
+}
+
 func BenchmarkGet8(b *testing.B)   { benchmarkGet(b, 8) }
 func BenchmarkGet32(b *testing.B)  { benchmarkGet(b, 32) }
 func BenchmarkGet128(b *testing.B) { benchmarkGet(b, 128) }
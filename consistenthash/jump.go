@@ -0,0 +1,81 @@
+package consistenthash
+
+import (
+	"sort"
+
+	"github.com/segmentio/fasthash/fnv1"
+)
+
+// Jump places keys with Jump Consistent Hash, from "A Fast, Minimal
+// Memory, Consistent Hash Algorithm" by Lamping and Veach. It spreads keys
+// evenly without any memory per item. Items are numbered in sorted order,
+// so adding or removing the item which sorts last only moves the keys
+// placed on it, while other changes move more keys.
+type Jump struct {
+	hash  Hash
+	items []string // sorted
+}
+
+// NewJump creates a Jump placement which hashes keys with fn, or with
+// fnv1.HashBytes64 if fn is nil.
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = fnv1.HashBytes64
+	}
+	return &Jump{hash: fn}
+}
+
+func (j *Jump) Add(items ...string) {
+	for _, item := range items {
+		if !contains(j.items, item) {
+			j.items = append(j.items, item)
+		}
+	}
+	sort.Strings(j.items)
+}
+
+func (j *Jump) Remove(items ...string) {
+	j.items = removeItems(j.items, items)
+}
+
+func (j *Jump) IsEmpty() bool {
+	return len(j.items) == 0
+}
+
+func (j *Jump) Get(key string) string {
+	if j.IsEmpty() {
+		return ""
+	}
+	return j.items[jump(mix(j.hash([]byte(key))), len(j.items))]
+}
+
+// GetN returns the item of key followed by the items which sort after it.
+func (j *Jump) GetN(key string, n int) []string {
+	if j.IsEmpty() || n <= 0 {
+		return nil
+	}
+	if n > len(j.items) {
+		n = len(j.items)
+	}
+	first := jump(mix(j.hash([]byte(key))), len(j.items))
+	items := make([]string, n)
+	for i := range items {
+		items[i] = j.items[(first+i)%len(j.items)]
+	}
+	return items
+}
+
+func (j *Jump) Clone() Placement {
+	return &Jump{hash: j.hash, items: append([]string(nil), j.items...)}
+}
+
+// jump returns the bucket of key among buckets.
+func jump(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,68 @@
+package consistenthash
+
+// Placement places keys on a set of items, such as the peers of a pool.
+// Map, Jump, Rendezvous and BoundedLoad are placements. A placement is
+// not safe for concurrent use, but a Clone can be updated while the
+// original is read.
+type Placement interface {
+	// Add adds items to the placement.
+	Add(items ...string)
+
+	// Remove removes items from the placement.
+	Remove(items ...string)
+
+	// IsEmpty reports whether the placement holds no items.
+	IsEmpty() bool
+
+	// Get returns the item on which key is placed.
+	Get(key string) string
+
+	// GetN returns up to n distinct items for key, in order of
+	// preference. The first item is the one returned by Get.
+	GetN(key string, n int) []string
+
+	// Clone returns a copy of the placement.
+	Clone() Placement
+}
+
+// WeightedPlacement is a Placement whose items can be weighted, for
+// example by the memory of each peer. Map, Rendezvous and BoundedLoad are
+// weighted placements.
+type WeightedPlacement interface {
+	Placement
+
+	// AddWeighted adds item with weight, or changes its weight if it is
+	// in the placement already. Items get shares of the keys proportional
+	// to their weights, and Add adds items with a weight of 1. A weight
+	// below 1 counts as 1.
+	AddWeighted(item string, weight int)
+}
+
+var (
+	_ WeightedPlacement = (*Map)(nil)
+	_ Placement         = (*Jump)(nil)
+	_ WeightedPlacement = (*Rendezvous)(nil)
+	_ WeightedPlacement = (*BoundedLoad)(nil)
+)
+
+// mix scrambles the bits of h, so that close hashes give distant values.
+// It is the finalizer of SplitMix64.
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// removeItems returns items without those in removed, reusing items.
+func removeItems(items []string, removed []string) []string {
+	kept := items[:0]
+	for _, item := range items {
+		if !contains(removed, item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
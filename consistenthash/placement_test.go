@@ -0,0 +1,257 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+	// maxLoad is the highest load of an item allowed by the distribution
+	// test, relative to the average.
+	maxLoad float64
+	// lastOnly is set if only adding or removing the last item in sorted
+	// order moves just the keys of that item.
+	lastOnly bool
+}{
+	{"ring", func() Placement { return New(50, nil) }, 1.6, false},
+	{"jump", func() Placement { return NewJump(nil) }, 1.1, true},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }, 1.1, false},
+	{"bounded_load", func() Placement { return NewBoundedLoad(50, nil, 1.1) }, 1.15, false},
+}
+
+// placementKeys returns n random keys, the same on every call.
+func placementKeys(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%016x", r.Uint64())
+	}
+	return keys
+}
+
+func placementItems(n int) []string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf("http://10.0.0.%02d:8080", i)
+	}
+	return items
+}
+
+func TestPlacementDistribution(t *testing.T) {
+	keys := placementKeys(100000)
+	for _, tt := range placements {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.new()
+			items := placementItems(10)
+			p.Add(items...)
+			loads := make(map[string]int)
+			for _, key := range keys {
+				loads[p.Get(key)]++
+			}
+			if len(loads) != len(items) {
+				t.Fatalf("keys placed on %d items, want %d", len(loads), len(items))
+			}
+			avg := float64(len(keys)) / float64(len(items))
+			var highest float64
+			for _, n := range loads {
+				highest = math.Max(highest, float64(n)/avg)
+			}
+			t.Logf("highest load %.3f of the average", highest)
+			if highest > tt.maxLoad {
+				t.Errorf("highest load is %.3f of the average, want at most %.2f", highest, tt.maxLoad)
+			}
+		})
+	}
+}
+
+func TestPlacementMovement(t *testing.T) {
+	keys := placementKeys(20000)
+	items := placementItems(11)
+	for _, tt := range placements {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.new()
+			p.Add(items[:10]...)
+			before := make(map[string]string)
+			for _, key := range keys {
+				before[key] = p.Get(key)
+			}
+
+			// Adding an item moves about its share of the keys, to it
+			added := p.Clone()
+			added.Add(items[10])
+			moved, movedElsewhere := 0, 0
+			for _, key := range keys {
+				if got := added.Get(key); got != before[key] {
+					moved++
+					if got != items[10] {
+						movedElsewhere++
+					}
+				}
+				if got := p.Get(key); got != before[key] {
+					t.Fatalf("Get(%q) on the original changed from %q to %q", key, before[key], got)
+				}
+			}
+			share := float64(len(keys)) / 11
+			t.Logf("adding moved %d keys, %d to other items", moved, movedElsewhere)
+			if float64(moved) > 1.5*share {
+				t.Errorf("adding an item moved %d keys, want about %.0f", moved, share)
+			}
+			if tt.name != "bounded_load" && movedElsewhere > 0 {
+				t.Errorf("adding an item moved %d keys between other items", movedElsewhere)
+			} else if float64(movedElsewhere) > 0.5*share {
+				t.Errorf("adding an item moved %d keys between other items", movedElsewhere)
+			}
+
+			// Removing an item only moves its keys
+			removed := added.Clone()
+			removed.Remove(items[10])
+			victim := items[10]
+			if !tt.lastOnly {
+				removed.Remove(items[3])
+				victim = items[3]
+			}
+			moved = 0
+			for _, key := range keys {
+				was := added.Get(key)
+				got := removed.Get(key)
+				if was == victim {
+					if got == victim {
+						t.Fatalf("Get(%q) = %q after removing it", key, got)
+					}
+					continue
+				}
+				if got != was && was != items[10] {
+					moved++
+				}
+			}
+			t.Logf("removing moved %d keys of other items", moved)
+			if tt.name != "bounded_load" && moved > 0 {
+				t.Errorf("removing an item moved %d keys of other items", moved)
+			} else if float64(moved) > 0.5*share {
+				t.Errorf("removing an item moved %d keys of other items", moved)
+			}
+		})
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	for _, tt := range placements {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.new()
+			if p.Get("key") != "" || p.GetN("key", 2) != nil {
+				t.Error("expected an empty placement to place no keys")
+			}
+			p.Add(placementItems(4)...)
+			for _, key := range placementKeys(100) {
+				got := p.GetN(key, 3)
+				if len(got) != 3 || got[0] != p.Get(key) {
+					t.Fatalf("GetN(%q, 3) = %v, want 3 items starting with %q", key, got, p.Get(key))
+				}
+				if got[0] == got[1] || got[0] == got[2] || got[1] == got[2] {
+					t.Errorf("GetN(%q, 3) = %v, want distinct items", key, got)
+				}
+			}
+			if got := p.GetN("key", 10); len(got) != 4 {
+				t.Errorf("GetN with n above the number of items = %v, want 4 items", got)
+			}
+			p.Remove(placementItems(4)...)
+			if !p.IsEmpty() {
+				t.Error("expected the placement to be empty after removing every item")
+			}
+		})
+	}
+}
+
+var weightedPlacements = []struct {
+	name string
+	new  func() WeightedPlacement
+	// maxError is the highest error allowed on the share of the keys of
+	// an item, relative to its share of the weights.
+	maxError float64
+}{
+	{"ring", func() WeightedPlacement { return New(100, nil) }, 0.2},
+	{"rendezvous", func() WeightedPlacement { return NewRendezvous(nil) }, 0.05},
+	{"bounded_load", func() WeightedPlacement { return NewBoundedLoad(100, nil, 1.1) }, 0.15},
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	keys := placementKeys(100000)
+	items := placementItems(6)
+	weights := []int{1, 1, 2, 2, 4, 8}
+	for _, tt := range weightedPlacements {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.new()
+			total := 0
+			for i, item := range items {
+				p.AddWeighted(item, weights[i])
+				total += weights[i]
+			}
+			loads := make(map[string]int)
+			for _, key := range keys {
+				loads[p.Get(key)]++
+			}
+			for i, item := range items {
+				want := float64(len(keys)) * float64(weights[i]) / float64(total)
+				ratio := float64(loads[item]) / want
+				t.Logf("weight %d: %d keys, %.3f of its share", weights[i], loads[item], ratio)
+				if math.Abs(ratio-1) > tt.maxError {
+					t.Errorf("item of weight %d got %d keys, want about %.0f", weights[i], loads[item], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedChange(t *testing.T) {
+	keys := placementKeys(20000)
+	items := placementItems(4)
+	for _, tt := range weightedPlacements {
+		if tt.name == "bounded_load" {
+			// slots held by other items move as their capacity changes
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.new()
+			p.Add(items...)
+			light := p.Clone().(WeightedPlacement)
+
+			// Raising a weight only moves keys to the item
+			p.AddWeighted(items[0], 3)
+			heavy := p.Clone().(WeightedPlacement)
+			gained := 0
+			for _, key := range keys {
+				was, got := light.Get(key), heavy.Get(key)
+				if was != got {
+					if got != items[0] {
+						t.Fatalf("Get(%q) moved from %q to %q", key, was, got)
+					}
+					gained++
+				}
+			}
+			if gained == 0 {
+				t.Error("raising a weight moved no keys")
+			}
+
+			// Lowering it back moves them back
+			p.AddWeighted(items[0], 1)
+			for _, key := range keys {
+				if got, want := p.Get(key), light.Get(key); got != want {
+					t.Fatalf("Get(%q) = %q after restoring the weight, want %q", key, got, want)
+				}
+			}
+
+			// Removing a weighted item removes all of it
+			heavy.Remove(items[0])
+			heavy.Add(items[0])
+			for _, key := range keys {
+				if got, want := heavy.Get(key), light.Get(key); got != want {
+					t.Fatalf("Get(%q) = %q after adding back the item, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+
+	"github.com/segmentio/fasthash/fnv1"
+)
+
+// Rendezvous places keys with Rendezvous, or Highest Random Weight,
+// hashing: each key goes to the item with the highest score for it.
+// Keys are spread evenly and only the keys of the items which are added
+// or removed move, but finding the item of a key takes time proportional
+// to the number of items. Weighted items get keys in proportion to their
+// weights, as in "Weighted Distributed Hash Tables" by Schindelhauer and
+// Schomaker.
+type Rendezvous struct {
+	hash    Hash
+	items   []string       // sorted
+	hashes  []uint64       // of items
+	scales  []float64      // weight of items
+	weights map[string]int // of the items added with a weight other than 1
+}
+
+// NewRendezvous creates a Rendezvous placement which hashes keys and
+// items with fn, or with fnv1.HashBytes64 if fn is nil.
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = fnv1.HashBytes64
+	}
+	return &Rendezvous{hash: fn}
+}
+
+func (r *Rendezvous) Add(items ...string) {
+	for _, item := range items {
+		if !contains(r.items, item) {
+			r.items = append(r.items, item)
+		}
+	}
+	sort.Strings(r.items)
+	r.rehash()
+}
+
+func (r *Rendezvous) AddWeighted(item string, weight int) {
+	if weight <= 1 {
+		delete(r.weights, item)
+	} else {
+		if r.weights == nil {
+			r.weights = make(map[string]int)
+		}
+		r.weights[item] = weight
+	}
+	r.Add(item)
+}
+
+func (r *Rendezvous) Remove(items ...string) {
+	r.items = removeItems(r.items, items)
+	for _, item := range items {
+		delete(r.weights, item)
+	}
+	r.rehash()
+}
+
+func (r *Rendezvous) rehash() {
+	r.hashes = make([]uint64, len(r.items))
+	r.scales = make([]float64, len(r.items))
+	for i, item := range r.items {
+		r.hashes[i] = r.hash([]byte(item))
+		r.scales[i] = 1
+		if w, ok := r.weights[item]; ok {
+			r.scales[i] = float64(w)
+		}
+	}
+}
+
+func (r *Rendezvous) IsEmpty() bool {
+	return len(r.items) == 0
+}
+
+// score returns the score of the i-th item for a key hashing to h. It is
+// -w/ln(u) for a weight w and u uniform in (0, 1), which the item wins
+// with a probability proportional to w.
+func (r *Rendezvous) score(i int, h uint64) float64 {
+	u := (float64(mix(r.hashes[i]^mix(h))>>11) + 0.5) / (1 << 53)
+	return -r.scales[i] / math.Log(u)
+}
+
+func (r *Rendezvous) Get(key string) string {
+	if r.IsEmpty() {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	best, bestScore := 0, r.score(0, h)
+	for i := 1; i < len(r.items); i++ {
+		if s := r.score(i, h); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return r.items[best]
+}
+
+// GetN returns the n items with the highest scores for key.
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if r.IsEmpty() || n <= 0 {
+		return nil
+	}
+	h := r.hash([]byte(key))
+	order := make([]int, len(r.items))
+	scores := make([]float64, len(r.items))
+	for i := range order {
+		order[i] = i
+		scores[i] = r.score(i, h)
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	if n > len(order) {
+		n = len(order)
+	}
+	items := make([]string, n)
+	for i := range items {
+		items[i] = r.items[order[i]]
+	}
+	return items
+}
+
+func (r *Rendezvous) Clone() Placement {
+	c := &Rendezvous{
+		hash:   r.hash,
+		items:  append([]string(nil), r.items...),
+		hashes: append([]uint64(nil), r.hashes...),
+		scales: append([]float64(nil), r.scales...),
+	}
+	if len(r.weights) > 0 {
+		c.weights = make(map[string]int, len(r.weights))
+		for item, w := range r.weights {
+			c.weights[item] = w
+		}
+	}
+	return c
+}
@@ -0,0 +1,107 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/mailgun/groupcache/v2/groupcachepb"
+	"github.com/mailgun/groupcache/v2/singleflight"
+)
+
+// ErrNotFound should be returned, possibly wrapped, by a Getter when the
+// requested key does not exist. If the group has a negative TTL set with
+// SetNegativeTTL the error is cached for that long, see CacheableError.
+var ErrNotFound = errors.New("groupcache: not found")
+
+// CacheableError wraps an error returned by a Getter to have the group
+// cache it in place of a value. Until the error expires, gets of the key
+// return the error without calling the Getter again, both on the owner
+// of the key and on peers which fetched it from the owner.
+type CacheableError struct {
+	// Err is the wrapped error.
+	Err error
+
+	// Expire is the time at which the cached error expires, in the same
+	// form as the expire time given to a Sink. If zero, it never expires.
+	Expire int64
+}
+
+func (e *CacheableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CacheableError) Unwrap() error {
+	return e.Err
+}
+
+// negativeView returns the view cached in place of a value when a Getter
+// returns err, and false if err is not cacheable.
+func (g *Group) negativeView(err error) (ByteView, bool) {
+	var ce *CacheableError
+	if errors.As(err, &ce) {
+		return ByteView{e: ce.Expire, err: ce}, true
+	}
+	if g.negativeTTL > 0 && errors.Is(err, ErrNotFound) {
+		ce = &CacheableError{Err: err, Expire: g.timer.Now() + int64(g.negativeTTL)}
+		return ByteView{e: ce.Expire, err: ce}, true
+	}
+	return ByteView{}, false
+}
+
+// PeerError is an error returned by a peer. Code preserves the kind of
+// error across the network, so errors.Is reports whether a PeerError
+// matches ErrNotFound, context.Canceled or context.DeadlineExceeded as
+// the original error did on the peer.
+type PeerError struct {
+	Code    pb.Status
+	Message string
+}
+
+func (e *PeerError) Error() string {
+	return e.Message
+}
+
+func (e *PeerError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == pb.Status_NOT_FOUND
+	case context.Canceled:
+		return e.Code == pb.Status_CANCELED
+	case context.DeadlineExceeded:
+		return e.Code == pb.Status_DEADLINE_EXCEEDED
+	}
+	return false
+}
+
+// errorCode returns the status sent to peers for err.
+func errorCode(err error) pb.Status {
+	var pe *PeerError
+	var panicErr *singleflight.PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		return pb.Status_INTERNAL
+	case errors.Is(err, ErrNotFound):
+		return pb.Status_NOT_FOUND
+	case errors.Is(err, context.Canceled):
+		return pb.Status_CANCELED
+	case errors.Is(err, context.DeadlineExceeded):
+		return pb.Status_DEADLINE_EXCEEDED
+	case errors.As(err, &pe) && pe.Code != pb.Status_OK:
+		return pe.Code
+	}
+	return pb.Status_UNKNOWN
+}
+
+// errorResponse returns the response sent to a peer for a cacheable error,
+// or false if err is not cacheable.
+func errorResponse(err error) (*pb.GetResponse, bool) {
+	var ce *CacheableError
+	if !errors.As(err, &ce) {
+		return nil, false
+	}
+	return &pb.GetResponse{
+		Expire: ce.Expire,
+		Status: errorCode(err),
+		Error:  ce.Error(),
+	}, true
+}
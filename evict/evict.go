@@ -0,0 +1,94 @@
+// Package evict provides the eviction policies of the caches of a group.
+//
+// A Policy stores entries and, when asked to, evicts the entry it values
+// least. The caches of a group are bounded by size in bytes rather than by
+// number of entries, so the group decides when to evict and the policy
+// decides what to evict.
+package evict
+
+import (
+	"github.com/mailgun/groupcache/v2/lru"
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+// Policy is a cache with an eviction policy. It is not safe for
+// concurrent access.
+//
+// The OnEvicted function given to the policy's Factory is called each
+// time an entry leaves the cache, whether it is evicted, removed, expired,
+// cleared or replaced by Add.
+type Policy interface {
+	// Add adds a value to the cache, replacing any value of key.
+	// If expire is not zero, the value expires at that time.
+	Add(key string, value interface{}, expire int64)
+
+	// Get looks up the value of key. Expired values are removed.
+	Get(key string) (value interface{}, ok bool)
+
+	// Remove removes key from the cache.
+	Remove(key string)
+
+	// Evict removes the entry chosen by the policy, if any.
+	Evict()
+
+	// RemoveExpired examines at most n entries, in no particular order,
+	// and removes those which have expired. It returns the number of
+	// entries removed.
+	RemoveExpired(n int) int
+
+	// Range calls fn for each entry which has not expired, in no
+	// particular order, until fn returns false. It does not count as a
+	// use of the entries. fn must not modify the cache.
+	Range(fn func(key string, value interface{}) bool)
+
+	// Len returns the number of entries in the cache.
+	Len() int
+
+	// Clear removes all entries from the cache.
+	Clear()
+}
+
+// OnEvicted is called when an entry leaves a Policy.
+type OnEvicted func(key string, value interface{})
+
+// Factory creates a Policy which reads the current time from t.
+// onEvicted may be nil.
+type Factory func(t timer.Timer, onEvicted OnEvicted) Policy
+
+// entry is an entry of a Policy.
+type entry struct {
+	key    string
+	value  interface{}
+	expire int64
+}
+
+func (e *entry) expired(t timer.Timer) bool {
+	return e.expire != 0 && e.expire < t.Now()
+}
+
+// NewLRU creates a Policy which evicts the least recently used entry.
+// It is the default policy of groups.
+func NewLRU(t timer.Timer, onEvicted OnEvicted) Policy {
+	c := lru.New(0, t)
+	if onEvicted != nil {
+		c.OnEvicted = func(key lru.Key, value interface{}) {
+			onEvicted(key.(string), value)
+		}
+	}
+	return lruPolicy{c}
+}
+
+type lruPolicy struct {
+	c *lru.Cache
+}
+
+func (p lruPolicy) Add(key string, value interface{}, expire int64) { p.c.Add(key, value, expire) }
+func (p lruPolicy) Get(key string) (interface{}, bool)              { return p.c.Get(key) }
+func (p lruPolicy) Remove(key string)                               { p.c.Remove(key) }
+func (p lruPolicy) Evict()                                          { p.c.RemoveOldest() }
+func (p lruPolicy) RemoveExpired(n int) int                         { return p.c.RemoveExpired(n) }
+func (p lruPolicy) Len() int                                        { return p.c.Len() }
+func (p lruPolicy) Range(fn func(key string, value interface{}) bool) {
+	p.c.Range(func(key lru.Key, value interface{}) bool { return fn(key.(string), value) })
+}
+func (p lruPolicy) Clear()                                          { p.c.Clear() }
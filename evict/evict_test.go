@@ -0,0 +1,218 @@
+package evict
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+var policies = []struct {
+	name    string
+	factory Factory
+}{
+	{"lru", NewLRU},
+	{"lfu", NewLFU},
+	{"tinylfu", NewTinyLFU},
+	{"s3fifo", NewS3FIFO},
+}
+
+func TestPolicies(t *testing.T) {
+	for _, p := range policies {
+		t.Run(p.name, func(t *testing.T) {
+			clock := timer.NewFake(time.Unix(0, 0))
+			evicted := make(map[string]int)
+			c := p.factory(clock, func(key string, value interface{}) {
+				if value != key {
+					t.Errorf("evicted %q with value %v", key, value)
+				}
+				evicted[key]++
+			})
+
+			c.Add("a", "a", 0)
+			if v, ok := c.Get("a"); !ok || v != "a" {
+				t.Fatalf("Get(a) = %v, %v; want a, true", v, ok)
+			}
+			if _, ok := c.Get("b"); ok {
+				t.Fatal("Get(b) hit on an empty key")
+			}
+
+			// Replacing a value reports the old one as evicted
+			c.Add("a", "a", 0)
+			if evicted["a"] != 1 || c.Len() != 1 {
+				t.Errorf("after replace: evicted %v, Len() = %d", evicted, c.Len())
+			}
+
+			c.Remove("a")
+			if _, ok := c.Get("a"); ok || evicted["a"] != 2 || c.Len() != 0 {
+				t.Errorf("after Remove: hit %v, evicted %v, Len() = %d", ok, evicted, c.Len())
+			}
+
+			// Expired values are removed by Get
+			c.Add("e", "e", clock.Now()+int64(time.Second))
+			clock.Advance(2 * time.Second)
+			if _, ok := c.Get("e"); ok || evicted["e"] != 1 || c.Len() != 0 {
+				t.Errorf("after expiry: hit %v, evicted %v, Len() = %d", ok, evicted, c.Len())
+			}
+
+			// RemoveExpired removes only expired entries, within its budget
+			for _, k := range []string{"x", "y", "z"} {
+				c.Add(k, k, clock.Now()+int64(time.Second))
+			}
+			c.Add("w", "w", 0)
+			clock.Advance(2 * time.Second)
+			if n := c.RemoveExpired(2); n > 2 {
+				t.Errorf("RemoveExpired(2) removed %d entries", n)
+			}
+			c.RemoveExpired(10)
+			if c.Len() != 1 || evicted["x"]+evicted["y"]+evicted["z"] != 3 {
+				t.Errorf("after RemoveExpired: evicted %v, Len() = %d", evicted, c.Len())
+			}
+
+			// Range skips expired entries and stops when told to
+			c.Add("v", "v", clock.Now()+int64(time.Second))
+			clock.Advance(2 * time.Second)
+			var seen []string
+			c.Range(func(key string, value interface{}) bool {
+				if value != key {
+					t.Errorf("Range() gave %q with value %v", key, value)
+				}
+				seen = append(seen, key)
+				return true
+			})
+			if len(seen) != 1 || seen[0] != "w" {
+				t.Errorf("Range() gave %v, want [w]", seen)
+			}
+			c.Add("u", "u", 0)
+			n := 0
+			c.Range(func(string, interface{}) bool {
+				n++
+				return false
+			})
+			if n != 1 {
+				t.Errorf("Range() went on after fn returned false, %d calls", n)
+			}
+			c.Remove("u")
+			c.Remove("v")
+			c.Remove("w")
+
+			// Evict removes exactly one entry until the cache is empty
+			for i := 0; i < 100; i++ {
+				k := fmt.Sprint(i)
+				c.Add(k, k, 0)
+				c.Get(k)
+			}
+			for i := 100; i > 90; i-- {
+				c.Evict()
+				if c.Len() != i-1 {
+					t.Fatalf("Len() = %d after Evict, want %d", c.Len(), i-1)
+				}
+			}
+
+			c.Clear()
+			c.Evict()
+			if c.Len() != 0 {
+				t.Errorf("Len() = %d after Clear, want 0", c.Len())
+			}
+			for i := 0; i < 100; i++ {
+				if k := fmt.Sprint(i); evicted[k] != 1 {
+					t.Errorf("key %q evicted %d times, want 1", k, evicted[k])
+				}
+			}
+		})
+	}
+}
+
+// zipfTrace returns n keys following a Zipf distribution over keys
+// distinct keys.
+func zipfTrace(r *rand.Rand, n, keys int) []string {
+	z := rand.NewZipf(r, 1.1, 1, uint64(keys-1))
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprint(z.Uint64())
+	}
+	return trace
+}
+
+// scanTrace returns zipfTrace interrupted every period keys by a scan of
+// scan keys which are never requested again.
+func scanTrace(r *rand.Rand, n, keys, period, scan int) []string {
+	var trace []string
+	for i, k := range zipfTrace(r, n, keys) {
+		if i%period == 0 {
+			for j := 0; j < scan; j++ {
+				trace = append(trace, fmt.Sprintf("scan-%d-%d", i, j))
+			}
+		}
+		trace = append(trace, k)
+	}
+	return trace
+}
+
+// hitRatio replays trace through a cache of size entries, adding the
+// keys which miss.
+func hitRatio(f Factory, size int, trace []string) float64 {
+	c := f(timer.Default{}, nil)
+	var hits int
+	for _, k := range trace {
+		if _, ok := c.Get(k); ok {
+			hits++
+			continue
+		}
+		c.Add(k, k, 0)
+		if c.Len() > size {
+			c.Evict()
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+// TestHitRatio compares the policies on synthetic traces. The traces are
+// generated with a fixed seed, so the ratios are deterministic, but they
+// only hint at how the policies behave on real workloads.
+func TestHitRatio(t *testing.T) {
+	traces := map[string][]string{
+		"zipf": zipfTrace(rand.New(rand.NewSource(1)), 100000, 10000),
+		"scan": scanTrace(rand.New(rand.NewSource(1)), 200000, 10000, 1000, 2000),
+	}
+	ratios := make(map[string]map[string]float64)
+	for name, trace := range traces {
+		ratios[name] = make(map[string]float64)
+		for _, p := range policies {
+			ratios[name][p.name] = hitRatio(p.factory, 500, trace)
+			t.Logf("%s/%s: %.3f", name, p.name, ratios[name][p.name])
+		}
+	}
+
+	for _, c := range []struct{ trace, better, worse string }{
+		{"zipf", "lfu", "lru"},
+		{"scan", "tinylfu", "lru"},
+		{"scan", "s3fifo", "lru"},
+	} {
+		if ratios[c.trace][c.better] <= ratios[c.trace][c.worse] {
+			t.Errorf("%s: expected %s (%.3f) to beat %s (%.3f)", c.trace,
+				c.better, ratios[c.trace][c.better], c.worse, ratios[c.trace][c.worse])
+		}
+	}
+}
+
+func BenchmarkPolicies(b *testing.B) {
+	trace := zipfTrace(rand.New(rand.NewSource(1)), 1<<16, 10000)
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			c := p.factory(timer.Default{}, nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				k := trace[i&(len(trace)-1)]
+				if _, ok := c.Get(k); !ok {
+					c.Add(k, k, 0)
+					if c.Len() > 1000 {
+						c.Evict()
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package evict
+
+import (
+	"container/list"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+// lfu evicts the least frequently used entry, and the least recently
+// used of those in case of a tie. All operations are O(1).
+type lfu struct {
+	timer     timer.Timer
+	onEvicted OnEvicted
+
+	items map[string]*lfuEntry
+	freqs *list.List // of *freqNode, by increasing frequency
+}
+
+type lfuEntry struct {
+	entry
+	node *list.Element // in freqs
+	elem *list.Element // in the items of node
+}
+
+// freqNode holds the entries used freq times, most recently used first.
+type freqNode struct {
+	freq  int
+	items *list.List // of *lfuEntry
+}
+
+// NewLFU creates a Policy which evicts the least frequently used entry.
+// Unlike LRU it keeps popular entries through scans, but entries which
+// were popular in the past are only evicted once less popular entries are.
+func NewLFU(t timer.Timer, onEvicted OnEvicted) Policy {
+	return &lfu{
+		timer:     t,
+		onEvicted: onEvicted,
+		items:     make(map[string]*lfuEntry),
+		freqs:     list.New(),
+	}
+}
+
+func (c *lfu) Add(key string, value interface{}, expire int64) {
+	if e, ok := c.items[key]; ok {
+		if c.onEvicted != nil {
+			c.onEvicted(key, e.value)
+		}
+		e.value = value
+		e.expire = expire
+		c.touch(e)
+		return
+	}
+	front := c.freqs.Front()
+	if front == nil || front.Value.(*freqNode).freq != 1 {
+		front = c.freqs.PushFront(&freqNode{freq: 1, items: list.New()})
+	}
+	e := &lfuEntry{entry: entry{key: key, value: value, expire: expire}, node: front}
+	e.elem = front.Value.(*freqNode).items.PushFront(e)
+	c.items[key] = e
+}
+
+func (c *lfu) Get(key string) (interface{}, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(c.timer) {
+		c.remove(e)
+		return nil, false
+	}
+	c.touch(e)
+	return e.value, true
+}
+
+// touch moves e to the node of the next frequency.
+func (c *lfu) touch(e *lfuEntry) {
+	node := e.node.Value.(*freqNode)
+	next := e.node.Next()
+	if next == nil || next.Value.(*freqNode).freq != node.freq+1 {
+		next = c.freqs.InsertAfter(&freqNode{freq: node.freq + 1, items: list.New()}, e.node)
+	}
+	c.unlink(e)
+	e.node = next
+	e.elem = next.Value.(*freqNode).items.PushFront(e)
+}
+
+// unlink removes e from its node, and the node if it becomes empty.
+func (c *lfu) unlink(e *lfuEntry) {
+	node := e.node.Value.(*freqNode)
+	node.items.Remove(e.elem)
+	if node.items.Len() == 0 {
+		c.freqs.Remove(e.node)
+	}
+}
+
+func (c *lfu) Remove(key string) {
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *lfu) Evict() {
+	front := c.freqs.Front()
+	if front == nil {
+		return
+	}
+	c.remove(front.Value.(*freqNode).items.Back().Value.(*lfuEntry))
+}
+
+func (c *lfu) RemoveExpired(n int) (removed int) {
+	for _, e := range c.items {
+		if n <= 0 {
+			break
+		}
+		n--
+		if e.expired(c.timer) {
+			c.remove(e)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *lfu) remove(e *lfuEntry) {
+	c.unlink(e)
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *lfu) Range(fn func(key string, value interface{}) bool) {
+	for _, e := range c.items {
+		if !e.expired(c.timer) && !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+func (c *lfu) Len() int {
+	return len(c.items)
+}
+
+func (c *lfu) Clear() {
+	if c.onEvicted != nil {
+		for _, e := range c.items {
+			c.onEvicted(e.key, e.value)
+		}
+	}
+	c.items = make(map[string]*lfuEntry)
+	c.freqs.Init()
+}
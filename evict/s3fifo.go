@@ -0,0 +1,208 @@
+package evict
+
+import (
+	"container/list"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+const (
+	// smallPercent is the share of entries in the small queue of s3FIFO.
+	smallPercent = 10
+	// maxFreq caps the frequency of s3FIFO entries.
+	maxFreq = 3
+)
+
+// s3FIFO implements S3-FIFO: new entries go into a small FIFO queue, and
+// only those used again while in it are moved to the main FIFO queue.
+// Keys evicted from the small queue are remembered in a ghost queue, and
+// enter the main queue directly if added again soon. Hits only update a
+// counter, which makes them cheaper than moving entries of an LRU.
+type s3FIFO struct {
+	timer     timer.Timer
+	onEvicted OnEvicted
+
+	items map[string]*list.Element // Value is a *s3FIFOEntry
+	small *list.List               // newest first
+	main  *list.List               // newest first
+
+	ghosts     map[string]*list.Element // Value is a key
+	ghostQueue *list.List               // newest first
+}
+
+type s3FIFOEntry struct {
+	entry
+	freq   int
+	inMain bool
+}
+
+// NewS3FIFO creates a Policy implementing S3-FIFO. It evicts entries
+// which are used only once quickly, which keeps scans from flushing the
+// cache.
+func NewS3FIFO(t timer.Timer, onEvicted OnEvicted) Policy {
+	return &s3FIFO{
+		timer:      t,
+		onEvicted:  onEvicted,
+		items:      make(map[string]*list.Element),
+		small:      list.New(),
+		main:       list.New(),
+		ghosts:     make(map[string]*list.Element),
+		ghostQueue: list.New(),
+	}
+}
+
+func (c *s3FIFO) Add(key string, value interface{}, expire int64) {
+	if ele, ok := c.items[key]; ok {
+		e := ele.Value.(*s3FIFOEntry)
+		if c.onEvicted != nil {
+			c.onEvicted(key, e.value)
+		}
+		e.value = value
+		e.expire = expire
+		e.hit()
+		return
+	}
+	e := &s3FIFOEntry{entry: entry{key: key, value: value, expire: expire}}
+	if g, ok := c.ghosts[key]; ok {
+		c.ghostQueue.Remove(g)
+		delete(c.ghosts, key)
+		e.inMain = true
+		c.items[key] = c.main.PushFront(e)
+		return
+	}
+	c.items[key] = c.small.PushFront(e)
+}
+
+func (c *s3FIFO) Get(key string) (interface{}, bool) {
+	ele, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*s3FIFOEntry)
+	if e.expired(c.timer) {
+		c.remove(ele)
+		return nil, false
+	}
+	e.hit()
+	return e.value, true
+}
+
+func (e *s3FIFOEntry) hit() {
+	if e.freq < maxFreq {
+		e.freq++
+	}
+}
+
+func (c *s3FIFO) Remove(key string) {
+	if ele, ok := c.items[key]; ok {
+		c.remove(ele)
+	}
+}
+
+func (c *s3FIFO) Evict() {
+	for len(c.items) > 0 {
+		if c.main.Len() == 0 || c.small.Len()*100 >= len(c.items)*smallPercent {
+			if c.evictSmall() {
+				return
+			}
+		} else if c.evictMain() {
+			return
+		}
+	}
+}
+
+// evictSmall evicts the oldest entry of the small queue, unless it was
+// used since it was added, in which case it is moved to the main queue.
+// It reports whether an entry was evicted.
+func (c *s3FIFO) evictSmall() bool {
+	ele := c.small.Back()
+	e := ele.Value.(*s3FIFOEntry)
+	if e.freq > 0 {
+		c.small.Remove(ele)
+		e.freq = 0
+		e.inMain = true
+		c.items[e.key] = c.main.PushFront(e)
+		return false
+	}
+	c.remove(ele)
+	c.addGhost(e.key)
+	return true
+}
+
+// evictMain evicts the oldest entry of the main queue, unless it was used
+// since it was last considered, in which case it is reinserted. It
+// reports whether an entry was evicted.
+func (c *s3FIFO) evictMain() bool {
+	ele := c.main.Back()
+	e := ele.Value.(*s3FIFOEntry)
+	if e.freq > 0 {
+		e.freq--
+		c.main.MoveToFront(ele)
+		return false
+	}
+	c.remove(ele)
+	return true
+}
+
+// addGhost remembers key, forgetting the oldest ghosts beyond the number
+// of entries in the cache.
+func (c *s3FIFO) addGhost(key string) {
+	c.ghosts[key] = c.ghostQueue.PushFront(key)
+	for c.ghostQueue.Len() > max(1, len(c.items)) {
+		delete(c.ghosts, c.ghostQueue.Remove(c.ghostQueue.Back()).(string))
+	}
+}
+
+func (c *s3FIFO) RemoveExpired(n int) (removed int) {
+	for _, ele := range c.items {
+		if n <= 0 {
+			break
+		}
+		n--
+		if ele.Value.(*s3FIFOEntry).expired(c.timer) {
+			c.remove(ele)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *s3FIFO) remove(ele *list.Element) {
+	e := ele.Value.(*s3FIFOEntry)
+	if e.inMain {
+		c.main.Remove(ele)
+	} else {
+		c.small.Remove(ele)
+	}
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *s3FIFO) Range(fn func(key string, value interface{}) bool) {
+	for _, ele := range c.items {
+		e := ele.Value.(*s3FIFOEntry)
+		if !e.expired(c.timer) && !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+func (c *s3FIFO) Len() int {
+	return len(c.items)
+}
+
+func (c *s3FIFO) Clear() {
+	if c.onEvicted != nil {
+		for _, ele := range c.items {
+			e := ele.Value.(*s3FIFOEntry)
+			c.onEvicted(e.key, e.value)
+		}
+	}
+	c.items = make(map[string]*list.Element)
+	c.small.Init()
+	c.main.Init()
+	c.ghosts = make(map[string]*list.Element)
+	c.ghostQueue.Init()
+}
@@ -0,0 +1,272 @@
+package evict
+
+import (
+	"container/list"
+
+	"github.com/mailgun/groupcache/v2/timer"
+	"github.com/segmentio/fasthash/fnv1a"
+)
+
+const (
+	// windowPercent is the share of entries in the admission window.
+	windowPercent = 1
+	// protectedPercent is the share of the main space which is protected.
+	protectedPercent = 80
+)
+
+// Segments of tinyLFU.
+const (
+	window = iota
+	probation
+	protected
+)
+
+// tinyLFU implements W-TinyLFU: new entries go through a small LRU
+// window, then into a segmented LRU split between probation and
+// protected entries. When an entry must be evicted, the oldest entry of
+// the window competes with the oldest entry of the main space, and the
+// one which was used less often, according to a frequency sketch of
+// recent accesses, is evicted.
+type tinyLFU struct {
+	timer     timer.Timer
+	onEvicted OnEvicted
+
+	items    map[string]*list.Element // Value is a *tinyLFUEntry
+	segments [3]*list.List            // most recently used first
+	sketch   *sketch
+}
+
+type tinyLFUEntry struct {
+	entry
+	hash    uint64
+	segment int
+}
+
+// NewTinyLFU creates a Policy implementing W-TinyLFU. It keeps
+// frequently used entries through scans, while adapting to changes in
+// popularity faster than LFU.
+func NewTinyLFU(t timer.Timer, onEvicted OnEvicted) Policy {
+	c := &tinyLFU{
+		timer:     t,
+		onEvicted: onEvicted,
+		items:     make(map[string]*list.Element),
+		sketch:    newSketch(minSketchWidth),
+	}
+	for i := range c.segments {
+		c.segments[i] = list.New()
+	}
+	return c
+}
+
+func (c *tinyLFU) Add(key string, value interface{}, expire int64) {
+	if ele, ok := c.items[key]; ok {
+		e := ele.Value.(*tinyLFUEntry)
+		if c.onEvicted != nil {
+			c.onEvicted(key, e.value)
+		}
+		e.value = value
+		e.expire = expire
+		c.access(ele)
+		return
+	}
+	e := &tinyLFUEntry{entry: entry{key: key, value: value, expire: expire}, hash: fnv1a.HashString64(key)}
+	c.items[key] = c.segments[window].PushFront(e)
+	if len(c.items) > c.sketch.width() {
+		c.sketch = newSketch(len(c.items) * 2)
+	}
+	c.sketch.increment(e.hash)
+
+	// Move the oldest entries of the window to probation
+	for c.segments[window].Len() > max(1, len(c.items)*windowPercent/100) {
+		c.moveTo(c.segments[window].Back(), probation)
+	}
+}
+
+func (c *tinyLFU) Get(key string) (interface{}, bool) {
+	ele, ok := c.items[key]
+	if !ok {
+		// Misses count too, so that entries loaded after a miss are
+		// admitted over entries which are never used.
+		c.sketch.increment(fnv1a.HashString64(key))
+		return nil, false
+	}
+	e := ele.Value.(*tinyLFUEntry)
+	if e.expired(c.timer) {
+		c.remove(ele)
+		return nil, false
+	}
+	c.access(ele)
+	return e.value, true
+}
+
+// access records a use of the entry of ele.
+func (c *tinyLFU) access(ele *list.Element) {
+	e := ele.Value.(*tinyLFUEntry)
+	c.sketch.increment(e.hash)
+	switch e.segment {
+	case window, protected:
+		c.segments[e.segment].MoveToFront(ele)
+	case probation:
+		c.moveTo(ele, protected)
+		mainLen := c.segments[probation].Len() + c.segments[protected].Len()
+		for c.segments[protected].Len() > max(1, mainLen*protectedPercent/100) {
+			c.moveTo(c.segments[protected].Back(), probation)
+		}
+	}
+}
+
+// moveTo moves the entry of ele to the front of segment.
+func (c *tinyLFU) moveTo(ele *list.Element, segment int) {
+	e := ele.Value.(*tinyLFUEntry)
+	c.segments[e.segment].Remove(ele)
+	e.segment = segment
+	c.items[e.key] = c.segments[segment].PushFront(e)
+}
+
+func (c *tinyLFU) Remove(key string) {
+	if ele, ok := c.items[key]; ok {
+		c.remove(ele)
+	}
+}
+
+func (c *tinyLFU) Evict() {
+	candidate := c.segments[window].Back()
+	victim := c.segments[probation].Back()
+	if victim == nil {
+		victim = c.segments[protected].Back()
+	}
+	switch {
+	case candidate == nil && victim == nil:
+		return
+	case candidate == nil:
+		c.remove(victim)
+	case victim == nil:
+		c.remove(candidate)
+	case c.sketch.estimate(candidate.Value.(*tinyLFUEntry).hash) > c.sketch.estimate(victim.Value.(*tinyLFUEntry).hash):
+		c.remove(victim)
+	default:
+		c.remove(candidate)
+	}
+}
+
+func (c *tinyLFU) RemoveExpired(n int) (removed int) {
+	for _, ele := range c.items {
+		if n <= 0 {
+			break
+		}
+		n--
+		if ele.Value.(*tinyLFUEntry).expired(c.timer) {
+			c.remove(ele)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *tinyLFU) remove(ele *list.Element) {
+	e := ele.Value.(*tinyLFUEntry)
+	c.segments[e.segment].Remove(ele)
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *tinyLFU) Range(fn func(key string, value interface{}) bool) {
+	for _, ele := range c.items {
+		e := ele.Value.(*tinyLFUEntry)
+		if !e.expired(c.timer) && !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+func (c *tinyLFU) Len() int {
+	return len(c.items)
+}
+
+func (c *tinyLFU) Clear() {
+	if c.onEvicted != nil {
+		for _, ele := range c.items {
+			e := ele.Value.(*tinyLFUEntry)
+			c.onEvicted(e.key, e.value)
+		}
+	}
+	c.items = make(map[string]*list.Element)
+	for _, l := range c.segments {
+		l.Init()
+	}
+}
+
+const (
+	minSketchWidth = 64
+	sketchDepth    = 4
+	maxCount       = 15
+)
+
+// sketchSeeds spread a hash over the rows of a sketch.
+var sketchSeeds = [sketchDepth]uint64{
+	0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93,
+}
+
+// sketch is a count-min sketch of the frequency of hashes, with counters
+// saturating at maxCount. Every 10 increments per counter of a row the
+// counters are halved, so that the sketch forgets old accesses.
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	shift     uint // 64 - log2(width)
+	additions int
+	resetAt   int
+}
+
+// newSketch creates a sketch with at least width counters per row.
+func newSketch(width int) *sketch {
+	w, bits := minSketchWidth, uint(6)
+	for w < width {
+		w <<= 1
+		bits++
+	}
+	s := &sketch{shift: 64 - bits, resetAt: 10 * w}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+func (s *sketch) width() int {
+	return len(s.rows[0])
+}
+
+func (s *sketch) increment(h uint64) {
+	for i := range s.rows {
+		if c := &s.rows[i][(h*sketchSeeds[i])>>s.shift]; *c < maxCount {
+			*c++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *sketch) estimate(h uint64) uint8 {
+	min := uint8(maxCount)
+	for i := range s.rows {
+		if c := s.rows[i][(h*sketchSeeds[i])>>s.shift]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/mailgun/groupcache/v2/timer"
 )
 
-func ExampleUsage() {
+func Example_usage() {
 	/*
 		// Keep track of peers in our cluster and add our instance to the pool `http://localhost:8080`
 		pool := groupcache.NewHTTPPoolOpts("http://localhost:8080", &groupcache.HTTPPoolOptions{})
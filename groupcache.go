@@ -27,16 +27,20 @@ package groupcache
 import (
 	"context"
 	"errors"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mailgun/groupcache/v2/evict"
 	pb "github.com/mailgun/groupcache/v2/groupcachepb"
-	"github.com/mailgun/groupcache/v2/lru"
 	"github.com/mailgun/groupcache/v2/singleflight"
 	"github.com/mailgun/groupcache/v2/timer"
+	"github.com/segmentio/fasthash/fnv1a"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var logger Logger
@@ -69,21 +73,10 @@ func (f GetterFunc) Get(ctx context.Context, key string, dest Sink) error {
 	return f(ctx, key, dest)
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-
-	initPeerServerOnce sync.Once
-	initPeerServer     func()
-)
-
 // GetGroup returns the named group previously created with NewGroup, or
 // nil if there's no such group.
 func GetGroup(name string) *Group {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+	return DefaultRegistry.GetGroup(name)
 }
 
 // NewGroup creates a coordinated group-aware Getter from a Getter.
@@ -96,82 +89,176 @@ func GetGroup(name string) *Group {
 //
 // The group name must be unique for each getter.
 func NewGroup(name string, cacheBytes int64, getter Getter, timer timer.Timer) *Group {
-	return newGroup(name, cacheBytes, getter, nil, timer)
+	return DefaultRegistry.NewGroup(name, cacheBytes, getter, timer)
+}
+
+// NewGroupWithOptions creates a group like NewGroup, configured by o.
+func NewGroupWithOptions(name string, getter Getter, o GroupOptions) *Group {
+	return DefaultRegistry.NewGroupWithOptions(name, getter, o)
+}
+
+// HotCacheOptions decide which values fetched from peers are mirrored in
+// the hot cache. Owners send the rate of requests from peers for each key
+// over the last minute with its value, as minute_qps, so that keys which
+// are only requested once in a while do not churn the hot cache.
+type HotCacheOptions struct {
+	// MinQPS is the rate of requests to the owner of a key, in queries
+	// per second, below which its value is not mirrored. If zero, every
+	// value is mirrored.
+	MinQPS float64
+
+	// CrossZoneFactor scales MinQPS for values fetched from a peer in
+	// another zone than the current peer, see TopologyPeerPicker, so that
+	// they are mirrored more readily. If zero, it defaults to 1/4.
+	CrossZoneFactor float64
+
+	// Admit reports whether the value of key should be mirrored, given
+	// the rate of requests reported by its owner. If not nil, it is used
+	// instead of MinQPS. Owners which predate minute_qps report 0.
+	Admit func(key string, minuteQPS float64) bool
+}
+
+// defaultCrossZoneFactor is the default HotCacheOptions.CrossZoneFactor.
+const defaultCrossZoneFactor = 1.0 / 4
+
+// admit reports whether a value with the given minuteQPS is mirrored.
+// crossZone is set if it was fetched from another zone.
+func (o HotCacheOptions) admit(key string, minuteQPS float64, crossZone bool) bool {
+	if o.Admit != nil {
+		return o.Admit(key, minuteQPS)
+	}
+	minQPS := o.MinQPS
+	if crossZone {
+		f := o.CrossZoneFactor
+		if f == 0 {
+			f = defaultCrossZoneFactor
+		}
+		minQPS *= f
+	}
+	return minuteQPS >= minQPS
+}
+
+// defaultHotCacheRatio is the default GroupOptions.HotCacheRatio.
+const defaultHotCacheRatio = 1.0 / 8
+
+// GroupOptions are the configurations of a Group.
+type GroupOptions struct {
+	// CacheBytes is the limit for the sum of the sizes of the main and
+	// hot caches. If zero, nothing is cached.
+	CacheBytes int64
+
+	// HotCacheRatio is the size of the hot cache, relative to the main
+	// cache, above which entries are evicted from the hot cache rather
+	// than from the main cache. If zero, it defaults to 1/8.
+	HotCacheRatio float64
+
+	// Timer is the source of the current time, for expiry.
+	// If nil, it defaults to timer.Default.
+	Timer timer.Timer
+
+	// PeerPicker picks the owners of keys. If nil, the peer picker
+	// registered with the group's Registry is used.
+	PeerPicker PeerPicker
+
+	// Logger logs the errors of the group. If nil, the logger set
+	// with SetLoggerFromLogger is used.
+	Logger Logger
+
+	// DefaultTTL is how long values loaded by the Getter without an
+	// expire time are cached. If zero, they never expire.
+	DefaultTTL time.Duration
+
+	// MaxValueSize is the size above which values are returned but not
+	// cached. If zero, values of any size are cached.
+	MaxValueSize int64
+
+	// LoadTimeout bounds the time spent loading a value which is not
+	// cached, from a peer or the Getter. If zero, loads are only bounded
+	// by the context of the request.
+	LoadTimeout time.Duration
+
+	// HotCache decides which values fetched from peers are mirrored in
+	// the hot cache. By default they all are.
+	HotCache HotCacheOptions
+
+	// Hedge optionally enables hedged requests when the owner of a key
+	// is slow to answer.
+	Hedge HedgeOptions
+
+	// Refresh, NegativeTTL, EvictionPolicy, CacheShards and ExpirySweep
+	// are as set by Group.SetRefreshOptions, Group.SetNegativeTTL,
+	// Group.SetEvictionPolicy, Group.SetCacheShards and
+	// Group.SetExpirySweep respectively.
+	Refresh        RefreshOptions
+	NegativeTTL    time.Duration
+	EvictionPolicy evict.Factory
+	CacheShards    int
+	ExpirySweep    ExpirySweepOptions
 }
 
 // DeregisterGroup removes group from group pool
 func DeregisterGroup(name string) {
-	mu.Lock()
-	delete(groups, name)
-	mu.Unlock()
+	DefaultRegistry.DeregisterGroup(name)
 }
 
 // If peers is nil, the peerPicker is called via a sync.Once to initialize it.
 func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker, timer timer.Timer) *Group {
-	if getter == nil {
-		panic("nil Getter")
-	}
-	mu.Lock()
-	defer mu.Unlock()
-	initPeerServerOnce.Do(callInitPeerServer)
-	if _, dup := groups[name]; dup {
-		panic("duplicate registration of group " + name)
-	}
-	g := &Group{
-		name:        name,
-		getter:      getter,
-		peers:       peers,
-		timer:       timer,
-		cacheBytes:  cacheBytes,
-		mainCache:   cache{timer: timer},
-		hotCache:    cache{timer: timer},
-		loadGroup:   &singleflight.Group{},
-		setGroup:    &singleflight.Group{},
-		removeGroup: &singleflight.Group{},
-	}
-	if fn := newGroupHook; fn != nil {
-		fn(g)
-	}
-	groups[name] = g
-	return g
+	return DefaultRegistry.newGroup(name, cacheBytes, getter, peers, timer)
 }
 
-// newGroupHook, if non-nil, is called right after a new group is created.
-var newGroupHook func(*Group)
-
 // RegisterNewGroupHook registers a hook that is run each time
 // a group is created.
 func RegisterNewGroupHook(fn func(*Group)) {
-	if newGroupHook != nil {
-		panic("RegisterNewGroupHook called more than once")
-	}
-	newGroupHook = fn
+	DefaultRegistry.RegisterNewGroupHook(fn)
+}
+
+// AddNewGroupHook adds a hook that is run each time a group is created.
+// Unlike RegisterNewGroupHook it may be called any number of times.
+func AddNewGroupHook(fn func(*Group)) {
+	DefaultRegistry.AddNewGroupHook(fn)
 }
 
 // RegisterServerStart registers a hook that is run when the first
 // group is created.
 func RegisterServerStart(fn func()) {
-	if initPeerServer != nil {
-		panic("RegisterServerStart called more than once")
-	}
-	initPeerServer = fn
-}
-
-func callInitPeerServer() {
-	if initPeerServer != nil {
-		initPeerServer()
-	}
+	DefaultRegistry.RegisterServerStart(fn)
 }
 
 // A Group is a cache namespace and associated data loaded spread over
 // a group of 1 or more machines.
 type Group struct {
 	name       string
+	registry   *Registry
 	getter     Getter
 	peersOnce  sync.Once
 	peers      PeerPicker
 	timer      timer.Timer
 	cacheBytes int64 // limit for sum of mainCache and hotCache size
+	logger     Logger
+
+	// hotCacheRatio is the size of hotCache relative to mainCache above
+	// which hotCache entries are evicted first.
+	hotCacheRatio float64
+
+	// defaultTTL is the time to live of loaded values without an expire time.
+	defaultTTL time.Duration
+
+	// maxValueSize is the size above which values are not cached, if not zero.
+	maxValueSize int64
+
+	// loadTimeout bounds each load of a value which is not cached, if not zero.
+	loadTimeout time.Duration
+
+	// hotCacheOpts decides which values fetched from peers are mirrored
+	// in hotCache.
+	hotCacheOpts HotCacheOptions
+
+	// rates counts the requests from peers for each key, which are sent
+	// back to them as minute_qps.
+	rates keyRates
+
+	// hedge decides when requests to owners are hedged, if enabled.
+	hedge *hedger
 
 	// mainCache is a cache of the keys for which this process
 	// (amongst its peers) is authoritative. That is, this cache
@@ -202,6 +289,22 @@ type Group struct {
 	// remotely once regardless of the number of concurrent callers.
 	removeGroup flightGroup
 
+	// refreshOpts configures the background refresh of stale values.
+	refreshOpts RefreshOptions
+
+	// negativeTTL is how long ErrNotFound returned by getter is cached.
+	negativeTTL time.Duration
+
+	// refreshes holds the keys with a background refresh in progress.
+	refreshes sync.Map
+
+	// peerStats holds the *PeerStats of each peer, keyed by URL.
+	peerStats sync.Map
+
+	// sweeper removes expired entries in the background, if enabled.
+	sweepMu sync.Mutex
+	sweeper *sweeper
+
 	_ int32 // force Stats to be 8-byte aligned on 32-bit platforms
 
 	// Stats are statistics on the group.
@@ -213,14 +316,67 @@ type Group struct {
 // implementation.
 type flightGroup interface {
 	Do(key string, fn func() (interface{}, error)) (interface{}, error)
+	DoChan(key string, fn func() (interface{}, error)) (<-chan singleflight.Result, bool)
 	Lock(fn func())
 }
 
+// RefreshOptions configure how a group refreshes cached values in the
+// background before they expire. A stale value is still returned to
+// callers while a single background load, deduplicated with any other
+// loads of the key, replaces it. Values are evicted once their expire
+// time passes regardless of these options.
+type RefreshOptions struct {
+	// SoftTTL is how long after being cached a value becomes stale.
+	// If zero, values do not become stale based on their age.
+	SoftTTL time.Duration
+
+	// RefreshAhead is the fraction of a value's lifetime, between 0
+	// and 1, which may remain before its expire time when it becomes
+	// stale. For example 0.1 refreshes a value which expires in 10
+	// minutes once less than 1 minute remains. It has no effect on
+	// values without an expire time. If zero, values do not become
+	// stale based on their expire time.
+	RefreshAhead float64
+}
+
+// SetRefreshOptions configures the background refresh of stale values.
+// It must be called before the group is used.
+func (g *Group) SetRefreshOptions(o RefreshOptions) {
+	g.refreshOpts = o
+}
+
+// SetNegativeTTL sets how long ErrNotFound errors returned by the group's
+// Getter are cached. If zero, the default, they are only cached when
+// wrapped in a CacheableError. It must be called before the group is used.
+func (g *Group) SetNegativeTTL(ttl time.Duration) {
+	g.negativeTTL = ttl
+}
+
+// SetCacheShards sets the number of shards of the main and hot caches.
+// Each shard has its own lock, and evicts its own entries when the group
+// is full, so more shards reduce contention between concurrent lookups
+// at the cost of a less exact eviction order. If not set, it defaults
+// to a power of 2 above 4 times GOMAXPROCS, at most 256. It must be
+// called before the group is used.
+func (g *Group) SetCacheShards(n int) {
+	g.mainCache.nshards = n
+	g.hotCache.nshards = n
+}
+
+// SetEvictionPolicy sets the policy which chooses the entries evicted
+// from the main and hot caches when the group is full, for example
+// evict.NewTinyLFU. If not set, the least recently used entries are
+// evicted. It must be called before the group is used.
+func (g *Group) SetEvictionPolicy(f evict.Factory) {
+	g.mainCache.newPolicy = f
+	g.hotCache.newPolicy = f
+}
+
 // Stats are per-group statistics.
 type Stats struct {
 	Gets                     AtomicInt // any Get request, including from peers
 	CacheHits                AtomicInt // either cache was good
-	GetFromPeersLatencyLower AtomicInt // slowest duration to request value from peers
+	GetFromPeersLatencyLower AtomicInt // Deprecated: slowest duration to request value from peers, use PeerGetLatency
 	PeerLoads                AtomicInt // either remote load or remote cache hit (not an error)
 	PeerErrors               AtomicInt
 	Loads                    AtomicInt // (gets - cacheHits)
@@ -228,6 +384,47 @@ type Stats struct {
 	LocalLoads               AtomicInt // total good local loads
 	LocalLoadErrs            AtomicInt // total bad local loads
 	ServerRequests           AtomicInt // gets that came over the network from peers
+	StaleHits                AtomicInt // cache hits on stale values
+	Refreshes                AtomicInt // background refreshes of stale values
+	RefreshErrs              AtomicInt // background refreshes which failed
+	HedgesFired              AtomicInt // hedged requests sent after the hedge delay
+	HedgesWon                AtomicInt // hedged requests which answered first
+	HandoffKeys              AtomicInt // values pushed to new owners when the peers changed
+	HandoffBytes             AtomicInt // bytes of the values pushed to new owners
+	HandoffErrs              AtomicInt // values which failed to be pushed to a new owner
+
+	PeerGetLatency    Histogram // requests to peers for values, including batches
+	PeerSetLatency    Histogram // Set requests to the owners of keys
+	PeerRemoveLatency Histogram // Remove requests to each peer
+	LocalLoadLatency  Histogram // calls to the Getter
+}
+
+// PeerStats are per-peer statistics of a group.
+type PeerStats struct {
+	GetLatency    Histogram
+	SetLatency    Histogram
+	RemoveLatency Histogram
+}
+
+// PeerStats returns the statistics of the requests made by the group to
+// each peer, keyed by the URL of the peer.
+func (g *Group) PeerStats() map[string]*PeerStats {
+	res := make(map[string]*PeerStats)
+	g.peerStats.Range(func(k, v interface{}) bool {
+		res[k.(string)] = v.(*PeerStats)
+		return true
+	})
+	return res
+}
+
+// statsFor returns the statistics of requests to peer.
+func (g *Group) statsFor(peer ProtoGetter) *PeerStats {
+	url := peer.GetURL()
+	if s, ok := g.peerStats.Load(url); ok {
+		return s.(*PeerStats)
+	}
+	s, _ := g.peerStats.LoadOrStore(url, &PeerStats{})
+	return s.(*PeerStats)
 }
 
 // Name returns the name of the group.
@@ -235,22 +432,125 @@ func (g *Group) Name() string {
 	return g.name
 }
 
+// owners returns the owners of key in the order of the peer picker, with
+// nil standing for this process.
+func (g *Group) owners(key string) []ProtoGetter {
+	if rp, ok := g.peers.(ReplicatedPeerPicker); ok {
+		return rp.PickPeers(key)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []ProtoGetter{peer}
+	}
+	return []ProtoGetter{nil}
+}
+
+// readOwners returns the owners of key in the order in which they are
+// tried by loads. If the peer picker is a TopologyPeerPicker and this
+// process has a zone, the owners in its zone come first. Owners after
+// this process are left in place, since they are never asked.
+func (g *Group) readOwners(key string) []ProtoGetter {
+	owners := g.owners(key)
+	tp, ok := g.peers.(TopologyPeerPicker)
+	if !ok || len(owners) < 2 {
+		return owners
+	}
+	zone := tp.Self().Zone
+	if zone == "" {
+		return owners
+	}
+	n := len(owners)
+	for i, peer := range owners {
+		if peer == nil {
+			n = i
+			break
+		}
+	}
+	res := make([]ProtoGetter, 0, len(owners))
+	for _, peer := range owners[:n] {
+		if tp.PeerInfo(peer).Zone == zone {
+			res = append(res, peer)
+		}
+	}
+	for _, peer := range owners[:n] {
+		if tp.PeerInfo(peer).Zone != zone {
+			res = append(res, peer)
+		}
+	}
+	return append(res, owners[n:]...)
+}
+
+// crossZone reports whether peer is known to be in another zone than
+// this process.
+func (g *Group) crossZone(peer ProtoGetter) bool {
+	tp, ok := g.peers.(TopologyPeerPicker)
+	if !ok {
+		return false
+	}
+	zone, peerZone := tp.Self().Zone, tp.PeerInfo(peer).Zone
+	return zone != "" && peerZone != "" && zone != peerZone
+}
+
+// ownersAfter returns the owners which follow peer in owners.
+func ownersAfter(owners []ProtoGetter, peer ProtoGetter) []ProtoGetter {
+	for i, p := range owners {
+		if p == peer {
+			return owners[i+1:]
+		}
+	}
+	return nil
+}
+
+func containsPeer(peers []ProtoGetter, peer ProtoGetter) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwner reports whether this process is one of the owners of key.
+func (g *Group) isOwner(key string) bool {
+	for _, peer := range g.owners(key) {
+		if peer == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// log returns the logger of the group, if any.
+func (g *Group) log() Logger {
+	if g.logger != nil {
+		return g.logger
+	}
+	return logger
+}
+
 func (g *Group) initPeers() {
 	if g.peers == nil {
-		g.peers = getPeers(g.name)
+		g.peers = g.registry.getPeers(g.name)
 	}
 }
 
-func (g *Group) Get(ctx context.Context, key string, dest Sink) error {
+func (g *Group) Get(ctx context.Context, key string, dest Sink) (err error) {
+	ctx, span := g.startSpan(ctx, "groupcache.Get")
+	defer func() { endSpan(span, err) }()
+
 	g.peersOnce.Do(g.initPeers)
 	g.Stats.Gets.Add(1)
 	if dest == nil {
 		return errors.New("groupcache: nil dest Sink")
 	}
 	value, cacheHit := g.lookupCache(key)
+	span.SetAttributes(attribute.Bool("groupcache.cache_hit", cacheHit))
 
 	if cacheHit {
 		g.Stats.CacheHits.Add(1)
+		g.refreshIfStale(key, value)
+		if value.err != nil {
+			return value.err
+		}
 		return setSinkView(dest, value)
 	}
 
@@ -259,39 +559,281 @@ func (g *Group) Get(ctx context.Context, key string, dest Sink) error {
 	// (if local) will set this; the losers will not. The common
 	// case will likely be one caller.
 	destPopulated := false
-	value, destPopulated, err := g.load(ctx, key, dest)
+	value, destPopulated, err = g.load(ctx, key, dest)
 	if err != nil {
 		return err
 	}
 	if destPopulated {
 		return nil
 	}
+	if value.err != nil {
+		return value.err
+	}
 	return setSinkView(dest, value)
 }
 
-func (g *Group) Set(ctx context.Context, key string, value []byte, expire int64, hotCache bool) error {
+// A GetManyResult holds the outcome of fetching a single key with
+// GetMany.
+type GetManyResult struct {
+	Key  string
+	Dest Sink // the Sink returned by the sink factory for Key
+	Err  error
+}
+
+// GetMany is like Get, but fetches several keys at once. sinkFactory is
+// called once per key to create the Sink the value is written to.
+//
+// Keys found in the local caches are served immediately. The remaining
+// keys are grouped by owner and each remote owner is sent a single batch
+// request, while keys owned by this process are loaded locally. Loads are
+// deduplicated with concurrent Get and GetMany calls for the same keys.
+//
+// The returned results are in the same order as keys.
+func (g *Group) GetMany(ctx context.Context, keys []string, sinkFactory func(key string) Sink) []GetManyResult {
+	ctx, span := g.startSpan(ctx, "groupcache.GetMany", attribute.Int("groupcache.keys", len(keys)))
+	defer span.End()
+
+	g.peersOnce.Do(g.initPeers)
+	results := make([]GetManyResult, len(keys))
+	first := make(map[string]int, len(keys)) // index of the first result for each key
+	var local []int
+	remote := make(map[ProtoGetter][]int)
+
+	for i, key := range keys {
+		g.Stats.Gets.Add(1)
+		results[i] = GetManyResult{Key: key, Dest: sinkFactory(key)}
+		if results[i].Dest == nil {
+			results[i].Err = errors.New("groupcache: nil dest Sink")
+			continue
+		}
+		if _, dup := first[key]; dup {
+			continue
+		}
+		first[key] = i
+
+		if value, cacheHit := g.lookupCache(key); cacheHit {
+			g.Stats.CacheHits.Add(1)
+			g.refreshIfStale(key, value)
+			if value.err != nil {
+				results[i].Err = value.err
+				continue
+			}
+			results[i].Err = setSinkView(results[i].Dest, value)
+			continue
+		}
+		if peer := g.readOwners(key)[0]; peer != nil {
+			remote[peer] = append(remote[peer], i)
+			continue
+		}
+		local = append(local, i)
+	}
+
+	var wg sync.WaitGroup
+	for _, i := range local {
+		wg.Add(1)
+		go func(r *GetManyResult) {
+			defer wg.Done()
+			defer recoverTo(&r.Err)
+			value, destPopulated, err := g.load(ctx, r.Key, r.Dest)
+			if err != nil {
+				r.Err = err
+				return
+			}
+			if value.err != nil {
+				r.Err = value.err
+				return
+			}
+			if !destPopulated {
+				r.Err = setSinkView(r.Dest, value)
+			}
+		}(&results[i])
+	}
+	for peer, idx := range remote {
+		wg.Add(1)
+		go func(peer ProtoGetter, idx []int) {
+			defer wg.Done()
+			g.loadMany(ctx, peer, idx, results)
+		}(peer, idx)
+	}
+	wg.Wait()
+
+	// Copy values to the sinks of duplicate keys
+	for i := range results {
+		r := &results[i]
+		j, ok := first[r.Key]
+		if r.Dest == nil || !ok || i == j {
+			continue
+		}
+		if results[j].Err != nil {
+			r.Err = results[j].Err
+			continue
+		}
+		value, err := results[j].Dest.view()
+		if err != nil {
+			r.Err = err
+			continue
+		}
+		r.Err = setSinkView(r.Dest, value)
+	}
+	return results
+}
+
+// serveGetMany fetches keys on behalf of a peer's batch request.
+func (g *Group) serveGetMany(ctx context.Context, keys []string) *pb.GetManyResponse {
+	results := g.GetMany(ctx, keys, func(string) Sink {
+		var b []byte
+		return AllocatingByteSliceSink(&b)
+	})
+
+	now := g.timer.Now()
+	out := &pb.GetManyResponse{Results: make([]*pb.GetManyResult, len(results))}
+	for i, res := range results {
+		out.Results[i] = &pb.GetManyResult{Key: res.Key}
+		qps := g.rates.add(res.Key, now)
+		if res.Err != nil {
+			if er, ok := errorResponse(res.Err); ok {
+				er.MinuteQps = qps
+				out.Results[i].Response = er
+				continue
+			}
+			out.Results[i].Error = res.Err.Error()
+			out.Results[i].Status = errorCode(res.Err)
+			continue
+		}
+		view, err := res.Dest.view()
+		if err != nil {
+			out.Results[i].Error = err.Error()
+			out.Results[i].Status = pb.Status_INTERNAL
+			continue
+		}
+		out.Results[i].Response = &pb.GetResponse{Value: view.ByteSlice(), Expire: view.e, MinuteQps: qps}
+	}
+	return out
+}
+
+// loadMany loads the keys of results at idx, all owned by peer, with
+// a single batch request. Keys which already have a load in flight join
+// that load instead of being requested again.
+func (g *Group) loadMany(ctx context.Context, peer ProtoGetter, idx []int, results []GetManyResult) {
+	if g.loadTimeout > 0 {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.loadTimeout)
+		defer cancel()
+	}
+	var (
+		cached   = make(map[string]ByteView)
+		fetched  map[string]peerResult
+		fetchErr error
+		keys     []string
+	)
+	done := make(chan struct{})
+	destPopulated := make([]bool, len(idx))
+	flights := make([]<-chan singleflight.Result, len(idx))
+
+	for n, i := range idx {
+		n, key, dest := n, results[i].Key, results[i].Dest
+		g.Stats.Loads.Add(1)
+		ch, leader := g.loadGroup.DoChan(key, func() (interface{}, error) {
+			<-done
+			if value, ok := cached[key]; ok {
+				return value, nil
+			}
+			r, ok := fetched[key]
+			if !ok {
+				r.err = fetchErr
+			}
+			if r.err == nil {
+				g.Stats.PeerLoads.Add(1)
+				return r.value, nil
+			}
+			if !g.fallbackAfterPeerError(ctx, peer, key, r.err) {
+				return nil, r.err
+			}
+			// Try the next owners of key, or load it locally
+			value, populated, err := g.fetchFrom(ctx, key, dest, ownersAfter(g.readOwners(key), peer))
+			if err != nil {
+				return nil, err
+			}
+			destPopulated[n] = populated
+			return value, nil
+		})
+		flights[n] = ch
+		if !leader {
+			continue
+		}
+		// Check the cache again, see the comment in load()
+		if value, cacheHit := g.lookupCache(key); cacheHit {
+			g.Stats.CacheHits.Add(1)
+			cached[key] = value
+			continue
+		}
+		g.Stats.LoadsDeduped.Add(1)
+		keys = append(keys, key)
+	}
+
+	if len(keys) != 0 {
+		func() {
+			defer recoverTo(&fetchErr)
+			start := time.Now()
+			fetched, fetchErr = g.getManyFromPeer(ctx, peer, keys)
+			g.recordPeerLatency(peer, start)
+		}()
+	}
+	close(done)
+
+	for n, i := range idx {
+		res := <-flights[n]
+		if res.Err != nil {
+			results[i].Err = res.Err
+			continue
+		}
+		value := res.Val.(ByteView)
+		if value.err != nil {
+			results[i].Err = value.err
+			continue
+		}
+		if !destPopulated[n] {
+			results[i].Err = setSinkView(results[i].Dest, value)
+		}
+	}
+}
+
+func (g *Group) Set(ctx context.Context, key string, value []byte, expire int64, hotCache bool) (err error) {
+	ctx, span := g.startSpan(ctx, "groupcache.Set")
+	defer func() { endSpan(span, err) }()
+
 	g.peersOnce.Do(g.initPeers)
 
 	if key == "" {
 		return errors.New("empty Set() key not allowed")
 	}
 
-	_, err := g.setGroup.Do(key, func() (interface{}, error) {
-		// If remote peer owns this key
-		owner, ok := g.peers.PickPeer(key)
-		if ok {
-			if err := g.setFromPeer(ctx, owner, key, value, expire); err != nil {
-				return nil, err
+	_, err = g.setGroup.Do(key, func() (interface{}, error) {
+		// Write to every owner of the key
+		var err error
+		local := false
+		for _, owner := range g.owners(key) {
+			if owner == nil {
+				// We own this key
+				g.localSet(key, value, expire, &g.mainCache)
+				local = true
+				continue
 			}
-			// TODO(thrawn01): Not sure if this is useful outside of tests...
-			//  maybe we should ALWAYS update the local cache?
-			if hotCache {
-				g.localSet(key, value, expire, &g.hotCache)
+			if e := g.setFromPeer(ctx, owner, key, value, expire); e != nil {
+				err = e
 			}
-			return nil, nil
 		}
-		// We own this key
-		g.localSet(key, value, expire, &g.mainCache)
+		if err != nil {
+			return nil, err
+		}
+		// TODO(thrawn01): Not sure if this is useful outside of tests...
+		//  maybe we should ALWAYS update the local cache?
+		if hotCache && !local {
+			g.localSet(key, value, expire, &g.hotCache)
+		}
 		return nil, nil
 	})
 	return err
@@ -299,13 +841,19 @@ func (g *Group) Set(ctx context.Context, key string, value []byte, expire int64,
 
 // Remove clears the key from our cache then forwards the remove
 // request to all peers.
-func (g *Group) Remove(ctx context.Context, key string) error {
+func (g *Group) Remove(ctx context.Context, key string) (err error) {
+	ctx, span := g.startSpan(ctx, "groupcache.Remove")
+	defer func() { endSpan(span, err) }()
+
 	g.peersOnce.Do(g.initPeers)
 
-	_, err := g.removeGroup.Do(key, func() (interface{}, error) {
-		// Remove from key owner first
-		owner, ok := g.peers.PickPeer(key)
-		if ok {
+	_, err = g.removeGroup.Do(key, func() (interface{}, error) {
+		// Remove from key owners first
+		owners := g.owners(key)
+		for _, owner := range owners {
+			if owner == nil {
+				continue
+			}
 			if err := g.removeFromPeer(ctx, owner, key); err != nil {
 				return nil, err
 			}
@@ -317,8 +865,8 @@ func (g *Group) Remove(ctx context.Context, key string) error {
 
 		// Asynchronously clear the key from all hot and main caches of peers
 		for _, peer := range g.peers.GetAll() {
-			// avoid deleting from owner a second time
-			if peer == owner {
+			// avoid deleting from owners a second time
+			if containsPeer(owners, peer) {
 				continue
 			}
 
@@ -346,10 +894,13 @@ func (g *Group) Remove(ctx context.Context, key string) error {
 }
 
 // Clear purges our cache then forwards the clear request to all peers.
-func (g *Group) Clear(ctx context.Context) error {
+func (g *Group) Clear(ctx context.Context) (err error) {
+	ctx, span := g.startSpan(ctx, "groupcache.Clear")
+	defer func() { endSpan(span, err) }()
+
 	g.peersOnce.Do(g.initPeers)
 
-	_, err := g.removeGroup.Do("", func() (interface{}, error) {
+	_, err = g.removeGroup.Do("", func() (interface{}, error) {
 		// Clear our cache first
 		g.localClear()
 		wg := sync.WaitGroup{}
@@ -382,6 +933,9 @@ func (g *Group) Clear(ctx context.Context) error {
 
 // load loads key either by invoking the getter locally or by sending it to another machine.
 func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView, destPopulated bool, err error) {
+	ctx, span := g.startSpan(ctx, "groupcache.load")
+	defer func() { endSpan(span, err) }()
+
 	g.Stats.Loads.Add(1)
 	viewi, err := g.loadGroup.Do(key, func() (interface{}, error) {
 		// Check the cache again because singleflight can only dedup calls
@@ -410,71 +964,225 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 			return value, nil
 		}
 		g.Stats.LoadsDeduped.Add(1)
-		var value ByteView
-		var err error
-		if peer, ok := g.peers.PickPeer(key); ok {
-
-			// metrics duration start
-			start := time.Now()
-
-			// get value from peers
-			value, err = g.getFromPeer(ctx, peer, key)
+		value, populated, err := g.fetch(ctx, key, dest)
+		if err != nil {
+			return nil, err
+		}
+		destPopulated = populated // only one caller of load gets this return value
+		return value, nil
+	})
+	if err == nil {
+		value = viewi.(ByteView)
+	}
+	return
+}
 
-			// metrics duration compute
-			duration := int64(time.Since(start)) / int64(time.Millisecond)
+// fetch loads key from the peer which owns it, or locally if this process
+// is the owner or the owner could not be reached. destPopulated reports
+// whether the value was loaded locally into dest.
+func (g *Group) fetch(ctx context.Context, key string, dest Sink) (value ByteView, destPopulated bool, err error) {
+	if g.loadTimeout > 0 {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.loadTimeout)
+		defer cancel()
+	}
+	return g.fetchFrom(ctx, key, dest, g.readOwners(key))
+}
 
-			// metrics only store the slowest duration
-			if g.Stats.GetFromPeersLatencyLower.Get() < duration {
-				g.Stats.GetFromPeersLatencyLower.Store(duration)
-			}
+// fetchFrom loads key from the first of owners which answers, where nil
+// stands for this process, see ReplicatedPeerPicker. If none of the
+// remote owners answers, key is loaded locally. The request to the first
+// owner is hedged if the group has HedgeOptions.
+func (g *Group) fetchFrom(ctx context.Context, key string, dest Sink, owners []ProtoGetter) (value ByteView, destPopulated bool, err error) {
+	var hedge *hedgeResult // the failed hedge request, if any
+	for i, peer := range owners {
+		if peer == nil {
+			break
+		}
+		if hedge != nil && peer == hedge.peer {
+			// already asked by the hedge request
+			continue
+		}
 
+		if i == 0 && g.hedge != nil {
+			value, hedge, err = g.fetchHedged(ctx, key, peer, owners)
 			if err == nil {
-				g.Stats.PeerLoads.Add(1)
-				return value, nil
-			} else if errors.Is(err, context.Canceled) {
-				// do not count context cancellation as a peer error
-				return nil, err
+				return value, false, nil
 			}
-
-			if logger != nil {
-				logger.Error().
-					WithFields(map[string]interface{}{
-						"err":      err,
-						"key":      key,
-						"category": "groupcache",
-					}).Printf("error retrieving key from peer '%s'", peer.GetURL())
+			if !g.fallbackAfterPeerError(ctx, peer, key, err) {
+				return ByteView{}, false, err
 			}
+			continue
+		}
 
-			g.Stats.PeerErrors.Add(1)
-			if ctx != nil && ctx.Err() != nil {
-				// Return here without attempting to get locally
-				// since the context is no longer valid
-				return nil, err
-			}
-			// TODO(bradfitz): log the peer's error? keep
-			// log of the past few for /groupcachez?  It's
-			// probably boring (normal task movement), so not
-			// worth logging I imagine.
+		// metrics duration start
+		start := time.Now()
+
+		// get value from peers
+		value, err = g.getFromPeer(ctx, peer, key)
+
+		g.recordPeerLatency(peer, start)
+
+		if err == nil {
+			g.Stats.PeerLoads.Add(1)
+			return value, false, nil
+		}
+		if !g.fallbackAfterPeerError(ctx, peer, key, err) {
+			return ByteView{}, false, err
 		}
+	}
 
-		value, err = g.getLocally(ctx, key, dest)
-		if err != nil {
-			g.Stats.LocalLoadErrs.Add(1)
-			return nil, err
+	if hedge != nil && hedge.peer == nil {
+		// the hedge request already failed to load key locally
+		return ByteView{}, false, hedge.err
+	}
+	value, err = g.loadLocally(ctx, key, dest)
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	return value, value.err == nil, nil
+}
+
+// recordPeerLatency updates the peer latency stats for a get request to
+// peer which began at start.
+func (g *Group) recordPeerLatency(peer ProtoGetter, start time.Time) {
+	d := time.Since(start)
+	g.Stats.PeerGetLatency.Observe(d)
+	g.statsFor(peer).GetLatency.Observe(d)
+
+	// the deprecated stat only stores the slowest duration
+	ms := int64(d / time.Millisecond)
+	for {
+		slowest := g.Stats.GetFromPeersLatencyLower.Get()
+		if slowest >= ms || atomic.CompareAndSwapInt64((*int64)(&g.Stats.GetFromPeersLatencyLower), slowest, ms) {
+			return
 		}
-		g.Stats.LocalLoads.Add(1)
-		destPopulated = true // only one caller of load gets this return value
-		g.populateCache(key, value, &g.mainCache)
-		return value, nil
-	})
-	if err == nil {
-		value = viewi.(ByteView)
 	}
-	return
+}
+
+// recoverTo recovers a panic of a goroutine started by the group, or of
+// a load on behalf of a peer, and sets *err to it as a
+// *singleflight.PanicError. Unlike in Get, there is no caller goroutine
+// to propagate the panic to. It must be deferred directly.
+func recoverTo(err *error) {
+	if v := recover(); v != nil {
+		*err = &singleflight.PanicError{Value: v, Stack: debug.Stack()}
+	}
+}
+
+// fallbackAfterPeerError records a failure to load key from peer and
+// reports whether the caller should fall back to loading it locally.
+func (g *Group) fallbackAfterPeerError(ctx context.Context, peer ProtoGetter, key string, err error) bool {
+	var pe *singleflight.PanicError
+	if errors.As(err, &pe) {
+		// a bug, which loading the key locally would not fix
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		// do not count context cancellation as a peer error
+		return false
+	}
+	if errors.Is(err, ErrNotFound) {
+		// the owner's getter has already looked for the key
+		return false
+	}
+
+	if logger := g.log(); logger != nil {
+		logger.Error().
+			WithFields(map[string]interface{}{
+				"err":      err,
+				"key":      key,
+				"category": "groupcache",
+			}).Printf("error retrieving key from peer '%s'", peer.GetURL())
+	}
+
+	g.Stats.PeerErrors.Add(1)
+	if ctx != nil && ctx.Err() != nil {
+		// Return here without attempting to get locally
+		// since the context is no longer valid
+		return false
+	}
+	// TODO(bradfitz): log the peer's error? keep
+	// log of the past few for /groupcachez?  It's
+	// probably boring (normal task movement), so not
+	// worth logging I imagine.
+	return true
+}
+
+// loadLocally invokes the getter for key and populates the main cache
+// with the result. If the getter returns a cacheable error, it is cached
+// and returned as a view holding the error.
+func (g *Group) loadLocally(ctx context.Context, key string, dest Sink) (ByteView, error) {
+	value, err := g.getLocally(ctx, key, dest)
+	if err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
+		if view, ok := g.negativeView(err); ok {
+			g.populateCache(key, view, &g.mainCache)
+			return view, nil
+		}
+		return ByteView{}, err
+	}
+	g.Stats.LocalLoads.Add(1)
+	if value.e == 0 && g.defaultTTL > 0 {
+		value.e = g.timer.Now() + int64(g.defaultTTL)
+	}
+	g.populateCache(key, value, &g.mainCache)
+	return value, nil
+}
+
+// refreshIfStale starts a background refresh of key if its cached value
+// is stale and no refresh of key is already in progress.
+func (g *Group) refreshIfStale(key string, value ByteView) {
+	if value.r == 0 || g.timer.Now() < value.r {
+		return
+	}
+	g.Stats.StaleHits.Add(1)
+	if _, busy := g.refreshes.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	go func() {
+		defer g.refreshes.Delete(key)
+		g.Stats.Refreshes.Add(1)
+		var err error
+		defer func() {
+			if err != nil {
+				g.Stats.RefreshErrs.Add(1)
+			}
+		}()
+		defer recoverTo(&err)
+		_, err = g.loadGroup.Do(key, func() (interface{}, error) {
+			var dest ByteView
+			value, _, err := g.fetch(context.Background(), key, ByteViewSink(&dest))
+			return value, err
+		})
+	}()
+}
+
+// refreshTime returns the time at which a value cached at now which
+// expires at expire becomes stale, or zero if it never does.
+func (g *Group) refreshTime(now, expire int64) int64 {
+	var r int64
+	if g.refreshOpts.SoftTTL > 0 {
+		r = now + int64(g.refreshOpts.SoftTTL)
+	}
+	if g.refreshOpts.RefreshAhead > 0 && expire > now {
+		ahead := expire - int64(float64(expire-now)*g.refreshOpts.RefreshAhead)
+		if r == 0 || ahead < r {
+			r = ahead
+		}
+	}
+	return r
 }
 
 func (g *Group) getLocally(ctx context.Context, key string, dest Sink) (ByteView, error) {
+	ctx, span := g.startSpan(ctx, "groupcache.getLocally")
+	start := time.Now()
 	err := g.getter.Get(ctx, key, dest)
+	g.Stats.LocalLoadLatency.Observe(time.Since(start))
+	endSpan(span, err)
 	if err != nil {
 		return ByteView{}, err
 	}
@@ -482,16 +1190,25 @@ func (g *Group) getLocally(ctx context.Context, key string, dest Sink) (ByteView
 }
 
 func (g *Group) getFromPeer(ctx context.Context, peer ProtoGetter, key string) (ByteView, error) {
+	ctx, span := g.startSpan(ctx, "groupcache.getFromPeer", peerAttr(peer))
 	req := &pb.GetRequest{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.GetResponse{}
 	err := peer.Get(ctx, req, res)
+	endSpan(span, err)
 	if err != nil {
 		return ByteView{}, err
 	}
 
+	return g.acceptFromPeer(peer, key, res)
+}
+
+// acceptFromPeer converts a value fetched from peer into a ByteView.
+// It populates the main cache with it if this process is also an owner
+// of key, otherwise the hot cache if the value is admitted.
+func (g *Group) acceptFromPeer(peer ProtoGetter, key string, res *pb.GetResponse) (ByteView, error) {
 	if res.Expire != 0 {
 		if g.timer.Now() > res.Expire {
 			return ByteView{}, errors.New("peer returned expired value")
@@ -499,12 +1216,76 @@ func (g *Group) getFromPeer(ctx context.Context, peer ProtoGetter, key string) (
 	}
 
 	value := ByteView{b: res.Value, e: res.Expire}
+	if res.Status != pb.Status_OK {
+		value = ByteView{e: res.Expire, err: &CacheableError{
+			Err:    &PeerError{Code: res.Status, Message: res.Error},
+			Expire: res.Expire,
+		}}
+	}
 
-	// Always populate the hot cache
-	g.populateCache(key, value, &g.hotCache)
+	switch {
+	case g.isOwner(key):
+		g.populateCache(key, value, &g.mainCache)
+	case g.hotCacheOpts.admit(key, res.MinuteQps, g.crossZone(peer)):
+		g.populateCache(key, value, &g.hotCache)
+	}
 	return value, nil
 }
 
+// getManyFromPeer fetches keys from peer, using a single request if the
+// peer implements BatchProtoGetter. It returns a result for every key
+// unless the request as a whole failed.
+func (g *Group) getManyFromPeer(ctx context.Context, peer ProtoGetter, keys []string) (map[string]peerResult, error) {
+	results := make(map[string]peerResult, len(keys))
+	bp, ok := peer.(BatchProtoGetter)
+	if !ok {
+		for _, key := range keys {
+			value, err := g.getFromPeer(ctx, peer, key)
+			results[key] = peerResult{value: value, err: err}
+		}
+		return results, nil
+	}
+
+	req := &pb.GetManyRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	res := &pb.GetManyResponse{}
+	ctx, span := g.startSpan(ctx, "groupcache.getManyFromPeer", peerAttr(peer),
+		attribute.Int("groupcache.keys", len(keys)))
+	err := bp.GetMany(ctx, req, res)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range res.Results {
+		var pr peerResult
+		if r.Error != "" {
+			code := r.Status
+			if code == pb.Status_OK {
+				// peers which predate the status field
+				code = pb.Status_UNKNOWN
+			}
+			pr.err = &PeerError{Code: code, Message: r.Error}
+		} else {
+			pr.value, pr.err = g.acceptFromPeer(peer, r.Key, r.GetResponse())
+		}
+		results[r.Key] = pr
+	}
+	for _, key := range keys {
+		if _, ok := results[key]; !ok {
+			results[key] = peerResult{err: errors.New("peer returned no result for key")}
+		}
+	}
+	return results, nil
+}
+
+// peerResult is the outcome of fetching a single key from a peer.
+type peerResult struct {
+	value ByteView
+	err   error
+}
+
 func (g *Group) setFromPeer(ctx context.Context, peer ProtoGetter, k string, v []byte, e int64) error {
 	req := &pb.SetRequest{
 		Expire: e,
@@ -512,7 +1293,14 @@ func (g *Group) setFromPeer(ctx context.Context, peer ProtoGetter, k string, v [
 		Key:    k,
 		Value:  v,
 	}
-	return peer.Set(ctx, req)
+	ctx, span := g.startSpan(ctx, "groupcache.setFromPeer", peerAttr(peer))
+	start := time.Now()
+	err := peer.Set(ctx, req)
+	endSpan(span, err)
+	d := time.Since(start)
+	g.Stats.PeerSetLatency.Observe(d)
+	g.statsFor(peer).SetLatency.Observe(d)
+	return err
 }
 
 func (g *Group) removeFromPeer(ctx context.Context, peer ProtoGetter, key string) error {
@@ -520,14 +1308,24 @@ func (g *Group) removeFromPeer(ctx context.Context, peer ProtoGetter, key string
 		Group: g.name,
 		Key:   key,
 	}
-	return peer.Remove(ctx, req)
+	ctx, span := g.startSpan(ctx, "groupcache.removeFromPeer", peerAttr(peer))
+	start := time.Now()
+	err := peer.Remove(ctx, req)
+	endSpan(span, err)
+	d := time.Since(start)
+	g.Stats.PeerRemoveLatency.Observe(d)
+	g.statsFor(peer).RemoveLatency.Observe(d)
+	return err
 }
 
 func (g *Group) clearFromPeer(ctx context.Context, peer ProtoGetter) error {
 	req := &pb.GetRequest{
 		Group: g.name,
 	}
-	return peer.Clear(ctx, req)
+	ctx, span := g.startSpan(ctx, "groupcache.clearFromPeer", peerAttr(peer))
+	err := peer.Clear(ctx, req)
+	endSpan(span, err)
+	return err
 }
 
 func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
@@ -588,6 +1386,12 @@ func (g *Group) populateCache(key string, value ByteView, cache *cache) {
 	if g.cacheBytes <= 0 {
 		return
 	}
+	if g.maxValueSize > 0 && int64(value.Len()) > g.maxValueSize {
+		// Do not keep a previous value of key either
+		cache.remove(key)
+		return
+	}
+	value.r = g.refreshTime(g.timer.Now(), value.e)
 	cache.add(key, value)
 
 	// Evict items from cache(s) if necessary.
@@ -602,10 +1406,10 @@ func (g *Group) populateCache(key string, value ByteView, cache *cache) {
 		// It should be something based on measurements and/or
 		// respecting the costs of different resources.
 		victim := &g.mainCache
-		if hotBytes > mainBytes/8 {
+		if float64(hotBytes) > float64(mainBytes)*g.hotCacheRatio {
 			victim = &g.hotCache
 		}
-		victim.removeOldest()
+		victim.evict()
 	}
 }
 
@@ -635,103 +1439,267 @@ func (g *Group) CacheStats(which CacheType) CacheStats {
 	}
 }
 
-// cache is a wrapper around an *lru.Cache that adds synchronization,
-// makes values always be ByteView, and counts the size of all keys and
-// values.
+// cache is a set of shards which together hold the values of a main or
+// hot cache. Each shard has its own lock, so that lookups of different
+// keys do not contend on a single mutex, and the size of all shards is
+// counted together, so that the group's cacheBytes limit applies to the
+// whole cache. The zero value is ready to use.
 type cache struct {
-	mu         sync.RWMutex
-	nbytes     int64 // of all keys and values
-	timer      timer.Timer
-	lru        *lru.Cache
-	nhit, nget int64
-	nevict     int64 // number of evictions
+	timer     timer.Timer
+	newPolicy evict.Factory // if nil, evict.NewLRU
+	nshards   int           // if zero, defaultCacheShards()
+
+	initOnce sync.Once
+	shards   []cacheShard
+	nbytes   atomic.Int64 // of all keys and values, in all shards
+	next     uint32       // next shard to consider for eviction
+}
+
+// defaultCacheShards returns the default number of shards of a cache, a
+// power of 2 above the number of CPUs so that concurrent lookups rarely
+// share a shard.
+func defaultCacheShards() int {
+	n := 1
+	for n < 4*runtime.GOMAXPROCS(0) && n < maxCacheShards {
+		n <<= 1
+	}
+	return n
+}
+
+const maxCacheShards = 256
+
+func (c *cache) init() {
+	c.initOnce.Do(func() {
+		n := c.nshards
+		if n <= 0 {
+			n = defaultCacheShards()
+		}
+		c.shards = make([]cacheShard, n)
+		for i := range c.shards {
+			c.shards[i].cache = c
+		}
+	})
+}
+
+func (c *cache) shard(key string) *cacheShard {
+	c.init()
+	h := fnv1a.HashString64(key)
+	return &c.shards[(h^h>>32)%uint64(len(c.shards))]
 }
 
 func (c *cache) stats() CacheStats {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return CacheStats{
-		Bytes:     c.nbytes,
-		Items:     c.itemsLocked(),
-		Gets:      c.nget,
-		Hits:      c.nhit,
-		Evictions: c.nevict,
+	c.init()
+	var s CacheStats
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.RLock()
+		s.Items += sh.itemsLocked()
+		s.Gets += sh.nget
+		s.Hits += sh.nhit
+		s.Evictions += sh.nevict
+		s.Expirations += sh.nexpire
+		sh.mu.RUnlock()
 	}
+	s.Bytes = c.bytes()
+	return s
 }
 
 func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
-		c.lru = lru.New(0, c.timer)
-		c.lru.OnEvicted = func(key lru.Key, value interface{}) {
-			val := value.(ByteView)
-			c.nbytes -= int64(len(key.(string))) + int64(val.Len())
-			c.nevict++
+	c.shard(key).add(key, value)
+}
+
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	return c.shard(key).get(key)
+}
+
+func (c *cache) remove(key string) {
+	c.shard(key).remove(key)
+}
+
+func (c *cache) clear() {
+	c.init()
+	for i := range c.shards {
+		c.shards[i].clear()
+	}
+}
+
+// evict evicts an entry from one of the shards holding at least their
+// share of the cache's bytes, taking turns between them so that the
+// shards stay about the same size.
+func (c *cache) evict() {
+	c.init()
+	n := uint32(len(c.shards))
+	share := c.bytes() / int64(n)
+	first := atomic.AddUint32(&c.next, 1)
+	for i := uint32(0); i < n; i++ {
+		sh := &c.shards[(first+i)%n]
+		if b := sh.nbytes.Load(); b > 0 && b >= share {
+			sh.evict()
+			return
 		}
 	}
-	c.lru.Add(key, value, value.Expire())
-	c.nbytes += int64(len(key)) + int64(value.Len())
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.nget++
-	if c.lru == nil {
+// removeExpired examines at most n entries, spread over the shards, and
+// removes those which have expired.
+func (c *cache) removeExpired(n int) {
+	c.init()
+	n /= len(c.shards)
+	if n < 1 {
+		n = 1
+	}
+	for i := range c.shards {
+		c.shards[i].removeExpired(n)
+	}
+}
+
+// entries returns the entries of the cache whose key satisfies keep.
+func (c *cache) entries(keep func(key string) bool) map[string]ByteView {
+	c.init()
+	res := make(map[string]ByteView)
+	for i := range c.shards {
+		c.shards[i].entries(keep, res)
+	}
+	return res
+}
+
+func (c *cache) bytes() int64 {
+	return c.nbytes.Load()
+}
+
+func (c *cache) items() int64 {
+	c.init()
+	var n int64
+	for i := range c.shards {
+		n += c.shards[i].items()
+	}
+	return n
+}
+
+// cacheShard is a shard of a cache. It wraps an evict.Policy, adding
+// synchronization, making values always be ByteView, and counting the
+// size of all keys and values.
+type cacheShard struct {
+	cache      *cache
+	mu         sync.RWMutex
+	nbytes     atomic.Int64 // of all keys and values, written with mu held
+	policy     evict.Policy
+	nhit, nget int64
+	nevict     int64 // number of evictions
+	nexpire    int64 // number of expired entries removed
+	expiring   bool  // whether entries leaving the policy have expired
+
+	// Keep shards on separate cache lines.
+	_ [64]byte
+}
+
+func (s *cacheShard) add(key string, value ByteView) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		newPolicy := s.cache.newPolicy
+		if newPolicy == nil {
+			newPolicy = evict.NewLRU
+		}
+		s.policy = newPolicy(s.cache.timer, func(key string, value interface{}) {
+			val := value.(ByteView)
+			s.addBytes(-int64(len(key)) - int64(val.Len()))
+			if s.expiring {
+				s.nexpire++
+			} else {
+				s.nevict++
+			}
+		})
+	}
+	s.policy.Add(key, value, value.Expire())
+	s.addBytes(int64(len(key)) + int64(value.Len()))
+}
+
+func (s *cacheShard) addBytes(n int64) {
+	s.nbytes.Add(n)
+	s.cache.nbytes.Add(n)
+}
+
+func (s *cacheShard) get(key string) (value ByteView, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nget++
+	if s.policy == nil {
 		return
 	}
-	vi, ok := c.lru.Get(key)
+	// Get only removes entries which have expired.
+	s.expiring = true
+	vi, ok := s.policy.Get(key)
+	s.expiring = false
 	if !ok {
 		return
 	}
-	c.nhit++
+	s.nhit++
 	return vi.(ByteView), true
 }
 
-func (c *cache) remove(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+func (s *cacheShard) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
 		return
 	}
-	c.lru.Remove(key)
+	s.policy.Remove(key)
 }
 
-func (c *cache) clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+func (s *cacheShard) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
 		return
 	}
-	c.lru.Clear()
+	s.policy.Clear()
 }
 
-func (c *cache) removeOldest() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru != nil {
-		c.lru.RemoveOldest()
+func (s *cacheShard) removeExpired(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		return
 	}
+	s.expiring = true
+	s.policy.RemoveExpired(n)
+	s.expiring = false
 }
 
-func (c *cache) bytes() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.nbytes
+func (s *cacheShard) entries(keep func(key string) bool, res map[string]ByteView) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.policy == nil {
+		return
+	}
+	s.policy.Range(func(key string, value interface{}) bool {
+		if keep(key) {
+			res[key] = value.(ByteView)
+		}
+		return true
+	})
 }
 
-func (c *cache) items() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.itemsLocked()
+func (s *cacheShard) evict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy != nil {
+		s.policy.Evict()
+	}
+}
+
+func (s *cacheShard) items() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.itemsLocked()
 }
 
-func (c *cache) itemsLocked() int64 {
-	if c.lru == nil {
+func (s *cacheShard) itemsLocked() int64 {
+	if s.policy == nil {
 		return 0
 	}
-	return int64(c.lru.Len())
+	return int64(s.policy.Len())
 }
 
 // An AtomicInt is an int64 to be accessed atomically.
@@ -758,9 +1726,10 @@ func (i *AtomicInt) String() string {
 
 // CacheStats are returned by stats accessors on Group.
 type CacheStats struct {
-	Bytes     int64
-	Items     int64
-	Gets      int64
-	Hits      int64
-	Evictions int64
+	Bytes       int64
+	Items       int64
+	Gets        int64
+	Hits        int64
+	Evictions   int64
+	Expirations int64 // expired entries removed by lookups or the sweeper
 }
@@ -19,26 +19,36 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"math/rand"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
 
 	"google.golang.org/protobuf/proto"
 
+	"github.com/mailgun/groupcache/v2/evict"
 	pb "github.com/mailgun/groupcache/v2/groupcachepb"
+	"github.com/mailgun/groupcache/v2/singleflight"
 	"github.com/mailgun/groupcache/v2/testpb"
 	"github.com/mailgun/groupcache/v2/timer"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	once                                 sync.Once
 	stringGroup, protoGroup, expireGroup Getter
 
+	// expireTimer is the timer of expireGroup.
+	expireTimer = timer.NewFake(time.Now())
+
 	stringc = make(chan string)
 
 	dummyCtx context.Context
@@ -80,8 +90,8 @@ func testSetup() {
 
 	expireGroup = NewGroup(expireGroupName, cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
 		cacheFills.Add(1)
-		return dest.SetString("ECHO:"+key, time.Now().Add(time.Millisecond*100).UnixNano())
-	}), timer.Default{})
+		return dest.SetString("ECHO:"+key, expireTimer.Now()+int64(time.Millisecond*100))
+	}), expireTimer)
 }
 
 // tests that a Getter's Get method is only called once with two
@@ -200,7 +210,7 @@ func TestCachingExpire(t *testing.T) {
 				t.Fatal(err)
 			}
 			if i == 1 {
-				time.Sleep(time.Millisecond * 150)
+				expireTimer.Advance(time.Millisecond * 150)
 			}
 		}
 	})
@@ -226,7 +236,7 @@ func TestCacheEviction(t *testing.T) {
 	}
 
 	g := stringGroup.(*Group)
-	evict0 := g.mainCache.nevict
+	evict0 := g.mainCache.stats().Evictions
 
 	// Trash the cache with other keys.
 	var bytesFlooded int64
@@ -237,7 +247,7 @@ func TestCacheEviction(t *testing.T) {
 		stringGroup.Get(dummyCtx, key, StringSink(&res))
 		bytesFlooded += int64(len(key) + len(res))
 	}
-	evicts := g.mainCache.nevict - evict0
+	evicts := g.mainCache.stats().Evictions - evict0
 	if evicts <= 0 {
 		t.Errorf("evicts = %v; want more than 0", evicts)
 	}
@@ -297,6 +307,7 @@ func TestCachePurging(t *testing.T) {
 type fakePeer struct {
 	hits int
 	fail bool
+	qps  float64 // sent as minute_qps
 }
 
 func (p *fakePeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
@@ -305,6 +316,7 @@ func (p *fakePeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse
 		return errors.New("simulated error from peer")
 	}
 	out.Value = []byte("got:" + in.GetKey())
+	out.MinuteQps = p.qps
 	return nil
 }
 
@@ -421,6 +433,129 @@ func TestPeers(t *testing.T) {
 	run("peer0_failing", 200, "localHits = 100, peers = 51 49 51")
 }
 
+type batchPeer struct {
+	fakePeer
+	batches int
+}
+
+func (p *batchPeer) GetMany(_ context.Context, in *pb.GetManyRequest, out *pb.GetManyResponse) error {
+	p.batches++
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	for _, key := range in.GetKeys() {
+		out.Results = append(out.Results, &pb.GetManyResult{
+			Key:      key,
+			Response: &pb.GetResponse{Value: []byte("got:" + key)},
+		})
+	}
+	return nil
+}
+
+func TestGetMany(t *testing.T) {
+	peer0 := &batchPeer{}
+	peer1 := &batchPeer{}
+	peer2 := &batchPeer{}
+	peerList := fakePeers([]ProtoGetter{peer0, peer1, peer2, nil})
+	var localHits AtomicInt
+	getter := func(_ context.Context, key string, dest Sink) error {
+		localHits.Add(1)
+		return dest.SetString("got:"+key, 0)
+	}
+	testGroup := newGroup("TestGetMany-group", cacheSize, GetterFunc(getter), peerList, timer.Default{})
+
+	run := func(name string, keys []string, wantSummary string) {
+		localHits.Store(0)
+		for _, p := range []*batchPeer{peer0, peer1, peer2} {
+			p.batches = 0
+		}
+
+		results := testGroup.GetMany(dummyCtx, keys, func(string) Sink {
+			return StringSink(new(string))
+		})
+		if len(results) != len(keys) {
+			t.Fatalf("%s: got %d results; want %d", name, len(results), len(keys))
+		}
+		for i, res := range results {
+			if res.Key != keys[i] {
+				t.Errorf("%s: result %d has key %q; want %q", name, i, res.Key, keys[i])
+			}
+			if res.Err != nil {
+				t.Errorf("%s: error on key %q: %v", name, res.Key, res.Err)
+				continue
+			}
+			v, _ := res.Dest.view()
+			if want := "got:" + res.Key; v.String() != want {
+				t.Errorf("%s: for key %q, got %q; want %q", name, res.Key, v.String(), want)
+			}
+		}
+		summary := fmt.Sprintf("localHits = %d, batches = %d %d %d",
+			localHits.Get(), peer0.batches, peer1.batches, peer2.batches)
+		if summary != wantSummary {
+			t.Errorf("%s: got %q; want %q", name, summary, wantSummary)
+		}
+	}
+
+	// Duplicate keys are only loaded once
+	keys := append(testKeys(200), "0", "1")
+	run("base", keys, "localHits = 51, batches = 1 1 1")
+
+	// Everything is served from the main and hot caches
+	run("cached", keys, "localHits = 0, batches = 0 0 0")
+
+	// A failing batch falls back to loading locally
+	testGroup.localClear()
+	peer0.fail = true
+	run("peer0_failing", keys, "localHits = 100, batches = 1 1 1")
+}
+
+// panicPeer panics on every request.
+type panicPeer struct{ fakePeer }
+
+func (p *panicPeer) Get(context.Context, *pb.GetRequest, *pb.GetResponse) error {
+	panic("peer bug")
+}
+
+func TestGetManyPanic(t *testing.T) {
+	getter := func(_ context.Context, key string, dest Sink) error {
+		if key == "boom" {
+			panic("getter bug")
+		}
+		return dest.SetString("got:"+key, 0)
+	}
+	for _, tt := range []struct {
+		name  string
+		peers PeerPicker
+		keys  []string
+		want  string // panic value of the failed keys
+	}{
+		{"getter", NoPeers{}, []string{"ok", "boom"}, "getter bug"},
+		{"peer", fakePeers{&panicPeer{}}, []string{"a", "b"}, "peer bug"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewRegistry().NewGroupWithOptions("TestGetManyPanic-group", GetterFunc(getter), GroupOptions{
+				CacheBytes: 1 << 20,
+				PeerPicker: tt.peers,
+			})
+			results := g.GetMany(dummyCtx, tt.keys, func(string) Sink {
+				return StringSink(new(string))
+			})
+			for _, res := range results {
+				if res.Key == "ok" {
+					if res.Err != nil {
+						t.Errorf("error on key %q: %v", res.Key, res.Err)
+					}
+					continue
+				}
+				var pe *singleflight.PanicError
+				if !errors.As(res.Err, &pe) || pe.Value != tt.want {
+					t.Errorf("error on key %q = %v, want a panic of %q", res.Key, res.Err, tt.want)
+				}
+			}
+		})
+	}
+}
+
 func TestTruncatingByteSliceTarget(t *testing.T) {
 	var buf [100]byte
 	s := buf[:]
@@ -482,6 +617,10 @@ func (g *orderedFlightGroup) Do(key string, fn func() (interface{}, error)) (int
 	return g.orig.Do(key, fn)
 }
 
+func (g *orderedFlightGroup) DoChan(key string, fn func() (interface{}, error)) (<-chan singleflight.Result, bool) {
+	return g.orig.DoChan(key, fn)
+}
+
 func (g *orderedFlightGroup) Lock(fn func()) {
 	fn()
 }
@@ -543,8 +682,8 @@ func TestNoDedup(t *testing.T) {
 	// upon entry, we would increment nbytes twice but the entry would
 	// only be in the cache once.
 	const wantBytes = int64(len(testkey) + len(testval))
-	if g.mainCache.nbytes != wantBytes {
-		t.Errorf("cache has %d bytes, want %d", g.mainCache.nbytes, wantBytes)
+	if g.mainCache.bytes() != wantBytes {
+		t.Errorf("cache has %d bytes, want %d", g.mainCache.bytes(), wantBytes)
 	}
 }
 
@@ -556,6 +695,23 @@ func TestGroupStatsAlignment(t *testing.T) {
 	}
 }
 
+func TestCacheAlignment(t *testing.T) {
+	var g Group
+	for _, off := range []uintptr{
+		unsafe.Offsetof(g.mainCache) + unsafe.Offsetof(g.mainCache.nbytes),
+		unsafe.Offsetof(g.hotCache) + unsafe.Offsetof(g.hotCache.nbytes),
+	} {
+		if off%8 != 0 {
+			t.Fatal("cache nbytes is not 8-byte aligned.")
+		}
+	}
+	// shards are held in a slice, so their size must keep each of them aligned
+	var s cacheShard
+	if unsafe.Offsetof(s.nbytes)%8 != 0 || unsafe.Sizeof(s)%8 != 0 {
+		t.Fatal("cacheShard nbytes is not 8-byte aligned.")
+	}
+}
+
 type slowPeer struct {
 	fakePeer
 }
@@ -598,8 +754,798 @@ func TestCacheRemovesBytesOfReplacedValues(t *testing.T) {
 	for _, bytes := range []int{100, 1000, 2000} {
 		c.add(key, ByteView{b: make([]byte, bytes)})
 		expected := int64(bytes + keyLen)
-		if c.nbytes != expected {
-			t.Fatalf("%s: expected %d was %d", t.Name(), expected, c.nbytes)
+		if c.bytes() != expected {
+			t.Fatalf("%s: expected %d was %d", t.Name(), expected, c.bytes())
+		}
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	var loads AtomicInt
+	g := newGroup("TestEvictionPolicy-group", 1000, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		loads.Add(1)
+		return dest.SetBytes(make([]byte, 90), 0)
+	}), NoPeers{}, timer.Default{})
+	g.SetEvictionPolicy(evict.NewLFU)
+
+	get := func(key string) {
+		var b []byte
+		if err := g.Get(dummyCtx, key, AllocatingByteSliceSink(&b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		get("hot")
+	}
+
+	// A scan of keys used once does not evict the frequently used key
+	for i := 0; i < 100; i++ {
+		get(fmt.Sprintf("scan-%d", i))
+	}
+	loads.Store(0)
+	get("hot")
+	if n := loads.Get(); n != 0 {
+		t.Errorf("hot key was loaded %d times after the scan, want 0", n)
+	}
+	if s := g.CacheStats(MainCache); s.Bytes > 1000 || s.Evictions == 0 {
+		t.Errorf("unexpected cache stats %+v", s)
+	}
+}
+
+func TestRefreshStale(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	var loads AtomicInt
+	g := newGroup("TestRefreshStale-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		n := loads.Get()
+		loads.Add(1)
+		return dest.SetString(fmt.Sprintf("%s:%d", key, n), clock.Now()+int64(time.Minute))
+	}), NoPeers{}, clock)
+	g.SetRefreshOptions(RefreshOptions{SoftTTL: time.Second})
+
+	get := func() string {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if v := get(); v != "key:0" {
+		t.Fatalf("got %q; want %q", v, "key:0")
+	}
+
+	// Not stale yet
+	clock.Advance(time.Second / 2)
+	if v := get(); v != "key:0" {
+		t.Fatalf("got %q; want %q", v, "key:0")
+	}
+	if n := g.Stats.StaleHits.Get(); n != 0 {
+		t.Fatalf("stale hits = %d; want 0", n)
+	}
+
+	// Stale, the old value is returned while it is refreshed
+	clock.Advance(time.Second)
+	if v := get(); v != "key:0" {
+		t.Fatalf("got %q; want %q", v, "key:0")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for get() != "key:1" {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting on refreshed value")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := g.Stats.Refreshes.Get(); n != 1 {
+		t.Errorf("refreshes = %d; want 1", n)
+	}
+
+	// Past the expire time the value is loaded in the foreground
+	clock.Advance(2 * time.Minute)
+	if v := get(); v != "key:2" {
+		t.Fatalf("got %q; want %q", v, "key:2")
+	}
+	if n := loads.Get(); n != 3 {
+		t.Errorf("loads = %d; want 3", n)
+	}
+}
+
+func TestRefreshTime(t *testing.T) {
+	const now = int64(1000 * time.Second)
+	tests := []struct {
+		name   string
+		opts   RefreshOptions
+		expire int64
+		want   int64
+	}{
+		{"disabled", RefreshOptions{}, now + int64(time.Minute), 0},
+		{"soft_ttl", RefreshOptions{SoftTTL: time.Second}, 0, now + int64(time.Second)},
+		{"refresh_ahead", RefreshOptions{RefreshAhead: 0.1}, now + int64(100*time.Second), now + int64(90*time.Second)},
+		{"refresh_ahead_no_expire", RefreshOptions{RefreshAhead: 0.1}, 0, 0},
+		{"earliest", RefreshOptions{SoftTTL: time.Minute, RefreshAhead: 0.5}, now + int64(time.Minute), now + int64(30*time.Second)},
+	}
+	for _, tt := range tests {
+		g := &Group{refreshOpts: tt.opts}
+		if got := g.refreshTime(now, tt.expire); got != tt.want {
+			t.Errorf("%s: refreshTime() = %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	var loads AtomicInt
+	errOther := errors.New("other error")
+	g := newGroup("TestNegativeCaching-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		loads.Add(1)
+		switch key {
+		case "missing":
+			return fmt.Errorf("key %q: %w", key, ErrNotFound)
+		case "cacheable":
+			return &CacheableError{Err: errOther, Expire: clock.Now() + int64(time.Second)}
+		}
+		return errOther
+	}), NoPeers{}, clock)
+	g.SetNegativeTTL(time.Minute)
+
+	tests := []struct {
+		key       string
+		want      error
+		wantLoads int64
+		ttl       time.Duration
+	}{
+		{"missing", ErrNotFound, 1, time.Minute},
+		{"cacheable", errOther, 1, time.Second},
+		{"uncacheable", errOther, 3, 0},
+	}
+	for _, tt := range tests {
+		loads.Store(0)
+		for i := 0; i < 3; i++ {
+			var s string
+			err := g.Get(dummyCtx, tt.key, StringSink(&s))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s: got error %v; want %v", tt.key, err, tt.want)
+			}
+		}
+		if n := loads.Get(); n != tt.wantLoads {
+			t.Errorf("%s: loads = %d; want %d", tt.key, n, tt.wantLoads)
+		}
+		if tt.ttl == 0 {
+			continue
+		}
+
+		// The error is loaded again once it expires
+		clock.Advance(tt.ttl + time.Millisecond)
+		var s string
+		g.Get(dummyCtx, tt.key, StringSink(&s))
+		if n := loads.Get(); n != tt.wantLoads+1 {
+			t.Errorf("%s: loads after expiry = %d; want %d", tt.key, n, tt.wantLoads+1)
+		}
+	}
+}
+
+type notFoundPeer struct {
+	fakePeer
+}
+
+func (p *notFoundPeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits++
+	out.Status = pb.Status_NOT_FOUND
+	out.Error = "not found: " + in.GetKey()
+	return nil
+}
+
+func TestNegativeCachingFromPeer(t *testing.T) {
+	peer := &notFoundPeer{}
+	var localHits int
+	g := newGroup("TestNegativeCachingFromPeer-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		localHits++
+		return dest.SetString("got:"+key, 0)
+	}), fakePeers([]ProtoGetter{peer}), timer.Default{})
+
+	for i := 0; i < 2; i++ {
+		var s string
+		err := g.Get(dummyCtx, "key", StringSink(&s))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v; want %v", err, ErrNotFound)
+		}
+		if err == nil || err.Error() != "not found: key" {
+			t.Errorf("got error %v; want message %q", err, "not found: key")
+		}
+	}
+	if peer.hits != 1 {
+		t.Errorf("peer hits = %d; want 1", peer.hits)
+	}
+	if localHits != 0 {
+		t.Errorf("local hits = %d; want 0", localHits)
+	}
+}
+
+type expiringPeer struct {
+	fakePeer
+	expire int64
+}
+
+func (p *expiringPeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits++
+	out.Value = []byte("peer:" + in.GetKey())
+	out.Expire = p.expire
+	return nil
+}
+
+func TestGetFromPeerExpired(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	peer := &expiringPeer{expire: clock.Now() + int64(time.Second)}
+	g := newGroup("TestGetFromPeerExpired-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("local:"+key, 0)
+	}), fakePeers([]ProtoGetter{peer}), clock)
+
+	var s string
+	if err := g.Get(dummyCtx, "a", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "peer:a" {
+		t.Errorf("got %q; want %q", s, "peer:a")
+	}
+
+	// Values which expired by the time they reach us are loaded locally
+	clock.Advance(2 * time.Second)
+	if err := g.Get(dummyCtx, "b", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "local:b" {
+		t.Errorf("got %q; want %q", s, "local:b")
+	}
+	if n := g.Stats.PeerErrors.Get(); n != 1 {
+		t.Errorf("peer errors = %d; want 1", n)
+	}
+}
+
+func TestPeerLatencyStats(t *testing.T) {
+	peer := &fakePeer{}
+	g := newGroup("TestPeerLatencyStats-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("local:"+key, 0)
+	}), fakePeers([]ProtoGetter{peer}), timer.Default{})
+
+	var s string
+	if err := g.Get(dummyCtx, "a", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Set(dummyCtx, "b", []byte("b"), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Remove(dummyCtx, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, h := range map[string]*Histogram{
+		"PeerGetLatency":    &g.Stats.PeerGetLatency,
+		"PeerSetLatency":    &g.Stats.PeerSetLatency,
+		"PeerRemoveLatency": &g.Stats.PeerRemoveLatency,
+	} {
+		if n := h.Snapshot().Count; n != 1 {
+			t.Errorf("%s count = %d; want 1", name, n)
+		}
+	}
+	if n := g.Stats.LocalLoadLatency.Snapshot().Count; n != 0 {
+		t.Errorf("LocalLoadLatency count = %d; want 0", n)
+	}
+
+	ps, ok := g.PeerStats()[peer.GetURL()]
+	if !ok {
+		t.Fatalf("no stats for peer %q", peer.GetURL())
+	}
+	if n := ps.GetLatency.Snapshot().Count; n != 1 {
+		t.Errorf("peer GetLatency count = %d; want 1", n)
+	}
+}
+
+func TestCacheShards(t *testing.T) {
+	g := newGroup("TestCacheShards-group", 1000, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetBytes(make([]byte, 40), 0)
+	}), NoPeers{}, timer.Default{})
+	g.SetCacheShards(8)
+
+	for i := 0; i < 200; i++ {
+		var b []byte
+		if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), AllocatingByteSliceSink(&b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The byte budget applies to all shards together, and evictions
+	// keep the shards about the same size.
+	s := g.CacheStats(MainCache)
+	if s.Bytes > 1000 || s.Items < 15 {
+		t.Errorf("unexpected cache stats %+v", s)
+	}
+	if n := len(g.mainCache.shards); n != 8 {
+		t.Fatalf("got %d shards, want 8", n)
+	}
+	for i := range g.mainCache.shards {
+		if n := g.mainCache.shards[i].items(); n == 0 || n > 5 {
+			t.Errorf("shard %d has %d items", i, n)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, shards := range []int{1, defaultCacheShards()} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			g := NewRegistry().newGroup("BenchmarkGet-group", 64<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+				return dest.SetString(key, 0)
+			}), NoPeers{}, timer.Default{})
+			g.SetCacheShards(shards)
+
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+				var s string
+				if err := g.Get(dummyCtx, keys[i], StringSink(&s)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			var seed int64
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+				var s string
+				for pb.Next() {
+					if err := g.Get(dummyCtx, keys[r.Intn(len(keys))], StringSink(&s)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestGroupOptions(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	loads := make(map[string]int)
+	g := NewRegistry().NewGroupWithOptions("TestGroupOptions-group", GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads[key]++
+		switch key {
+		case "big":
+			return dest.SetBytes(make([]byte, 100), 0)
+		case "slow":
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return dest.SetString("value", 0)
+	}), GroupOptions{
+		CacheBytes:   1 << 20,
+		Timer:        clock,
+		PeerPicker:   fakePeers{&fakePeer{fail: true}},
+		Logger:       LogrusLogger{Entry: logrus.NewEntry(l)},
+		DefaultTTL:   time.Minute,
+		MaxValueSize: 50,
+		LoadTimeout:  10 * time.Millisecond,
+	})
+	get := func(key string) error {
+		var b []byte
+		return g.Get(dummyCtx, key, AllocatingByteSliceSink(&b))
+	}
+
+	// Values without an expire time expire after DefaultTTL
+	for i := 0; i < 2; i++ {
+		if err := get("small"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(2 * time.Minute)
+	if err := get("small"); err != nil {
+		t.Fatal(err)
+	}
+	if loads["small"] != 2 {
+		t.Errorf("small loaded %d times, want 2", loads["small"])
+	}
+
+	// Values above MaxValueSize are not cached
+	for i := 0; i < 2; i++ {
+		if err := get("big"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads["big"] != 2 {
+		t.Errorf("big loaded %d times, want 2", loads["big"])
+	}
+
+	// Loads are bounded by LoadTimeout
+	if err := get("slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Peer errors are logged with the group's logger
+	if !strings.Contains(buf.String(), "error retrieving key from peer") {
+		t.Errorf("peer error was not logged, got %q", buf.String())
+	}
+
+	// Batched loads from a peer are bounded by LoadTimeout as well
+	g = NewRegistry().NewGroupWithOptions("TestGroupOptions-group", GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		t.Errorf("unexpected local load of %q", key)
+		return nil
+	}), GroupOptions{
+		CacheBytes:  1 << 20,
+		PeerPicker:  fakePeers{&hangingPeer{}},
+		LoadTimeout: 10 * time.Millisecond,
+	})
+	results := g.GetMany(dummyCtx, []string{"slow"}, func(string) Sink { return StringSink(new(string)) })
+	if err := results[0].Err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetMany got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// hangingPeer answers batches when their context is done.
+type hangingPeer struct{ fakePeer }
+
+func (p *hangingPeer) GetMany(ctx context.Context, in *pb.GetManyRequest, out *pb.GetManyResponse) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHotCacheAdmission(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		opts    HotCacheOptions
+		qps     float64
+		wantHot bool
+	}{
+		{"default", HotCacheOptions{}, 0, true},
+		{"below_min_qps", HotCacheOptions{MinQPS: 1}, 0.5, false},
+		{"above_min_qps", HotCacheOptions{MinQPS: 1}, 2, true},
+		{"admit_func", HotCacheOptions{MinQPS: 100, Admit: func(key string, qps float64) bool {
+			return key == "admitted"
+		}}, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := &fakePeer{qps: tt.qps}
+			g := NewRegistry().NewGroupWithOptions("TestHotCacheAdmission-group", GetterFunc(func(_ context.Context, key string, dest Sink) error {
+				t.Fatalf("unexpected local load of %q", key)
+				return nil
+			}), GroupOptions{
+				CacheBytes: 1 << 20,
+				PeerPicker: fakePeers{peer},
+				HotCache:   tt.opts,
+			})
+			for i := 0; i < 2; i++ {
+				var s string
+				if err := g.Get(dummyCtx, "admitted", StringSink(&s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if hot := g.CacheStats(HotCache).Items == 1; hot != tt.wantHot {
+				t.Errorf("value in hot cache = %v, want %v", hot, tt.wantHot)
+			}
+			if want := map[bool]int{true: 1, false: 2}[tt.wantHot]; peer.hits != want {
+				t.Errorf("peer hit %d times, want %d", peer.hits, want)
+			}
+		})
+	}
+}
+
+func TestMinuteQPS(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	g := NewRegistry().NewGroupWithOptions("TestMinuteQPS-group", GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("value", 0)
+	}), GroupOptions{CacheBytes: 1 << 20, PeerPicker: NoPeers{}, Timer: clock})
+
+	get := func(key string) float64 {
+		t.Helper()
+		res, err := getResponse(dummyCtx, g, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.MinuteQps
+	}
+	for i := 0; i < 59; i++ {
+		get("a")
+	}
+	if qps := get("a"); qps != 1 {
+		t.Errorf("minute_qps = %v after 60 requests, want 1", qps)
+	}
+	if qps := get("b"); qps != 1.0/60 {
+		t.Errorf("minute_qps of another key = %v, want %v", qps, 1.0/60)
+	}
+
+	// Half of the previous minute still counts
+	clock.Advance(90 * time.Second)
+	if qps := get("a"); qps != 31.0/60 {
+		t.Errorf("minute_qps = %v after 90s, want %v", qps, 31.0/60)
+	}
+
+	// Requests older than two minutes are forgotten
+	clock.Advance(3 * time.Minute)
+	if qps := get("a"); qps != 1.0/60 {
+		t.Errorf("minute_qps = %v after 4m30s, want %v", qps, 1.0/60)
+	}
+
+	out := g.serveGetMany(dummyCtx, []string{"c", "c"})
+	if qps := out.Results[1].Response.MinuteQps; qps != 2.0/60 {
+		t.Errorf("batch minute_qps = %v, want %v", qps, 2.0/60)
+	}
+}
+
+// hedgePeer answers Get with its name, after delay or once its context is
+// done, whichever comes first.
+type hedgePeer struct {
+	hits     AtomicInt // first, to be 8-byte aligned on 32-bit platforms
+	name     string
+	delay    time.Duration
+	fail     bool
+	canceled chan struct{} // closed when a Get is canceled
+}
+
+func (p *hedgePeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits.Add(1)
+	select {
+	case <-time.After(p.delay):
+		if p.fail {
+			return errors.New("simulated error from peer")
+		}
+		out.Value = []byte(p.name + ":" + in.GetKey())
+		return nil
+	case <-ctx.Done():
+		close(p.canceled)
+		return ctx.Err()
+	}
+}
+
+func (p *hedgePeer) Remove(context.Context, *pb.GetRequest) error { return nil }
+func (p *hedgePeer) Set(context.Context, *pb.SetRequest) error    { return nil }
+func (p *hedgePeer) Clear(context.Context, *pb.GetRequest) error  { return nil }
+func (p *hedgePeer) GetURL() string                               { return p.name }
+
+// successorPeers owns every key by owner, followed by successor.
+type successorPeers struct {
+	owner, successor ProtoGetter
+}
+
+func (p successorPeers) PickPeer(key string) (ProtoGetter, bool) { return p.owner, true }
+func (p successorPeers) GetAll() []ProtoGetter                   { return []ProtoGetter{p.owner, p.successor} }
+func (p successorPeers) PickSuccessor(key string) (ProtoGetter, bool) {
+	return p.successor, p.successor != nil
+}
+
+func TestHedgedRequests(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		ownerDelay time.Duration
+		successor  ProtoGetter
+		want       string
+		fired, won int64
+	}{
+		{"owner_answers", 0, &hedgePeer{name: "successor"}, "owner:key", 0, 0},
+		{"successor_wins", time.Hour, &hedgePeer{name: "successor"}, "successor:key", 1, 1},
+		{"local_wins", time.Hour, nil, "local:key", 1, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := &hedgePeer{name: "owner", delay: tt.ownerDelay, canceled: make(chan struct{})}
+			g := NewRegistry().NewGroupWithOptions("TestHedgedRequests-group", GetterFunc(func(_ context.Context, key string, dest Sink) error {
+				return dest.SetString("local:"+key, 0)
+			}), GroupOptions{
+				CacheBytes: 1 << 20,
+				PeerPicker: successorPeers{owner: owner, successor: tt.successor},
+				Hedge:      HedgeOptions{Delay: 10 * time.Millisecond},
+			})
+
+			var got string
+			if err := g.Get(context.Background(), "key", StringSink(&got)); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+			if n := g.Stats.HedgesFired.Get(); n != tt.fired {
+				t.Errorf("HedgesFired = %d, want %d", n, tt.fired)
+			}
+			if n := g.Stats.HedgesWon.Get(); n != tt.won {
+				t.Errorf("HedgesWon = %d, want %d", n, tt.won)
+			}
+			if tt.won > 0 {
+				select {
+				case <-owner.canceled:
+				case <-time.After(time.Second):
+					t.Error("expected the request to the owner to be canceled")
+				}
+			}
+		})
+	}
+}
+
+// replicatedPeers owns every key on all of its peers, in order.
+type replicatedPeers struct{ fakePeers }
+
+func (p replicatedPeers) PickPeers(key string) []ProtoGetter {
+	return append([]ProtoGetter(nil), p.fakePeers...)
+}
+
+func TestHedgeFailures(t *testing.T) {
+	owner := &hedgePeer{name: "owner", delay: 30 * time.Millisecond, fail: true}
+	replica := &hedgePeer{name: "replica", fail: true}
+	g := NewRegistry().NewGroupWithOptions("TestHedgeFailures-group", GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("local:"+key, 0)
+	}), GroupOptions{
+		CacheBytes: 1 << 20,
+		PeerPicker: replicatedPeers{fakePeers{owner, replica}},
+		Hedge:      HedgeOptions{Delay: 10 * time.Millisecond, Percentile: 0.5},
+	})
+
+	// The replica asked by the hedge is not asked again
+	var got string
+	if err := g.Get(context.Background(), "key", StringSink(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if got != "local:key" {
+		t.Errorf("Get() = %q, want %q", got, "local:key")
+	}
+	if n := replica.hits.Get(); n != 1 {
+		t.Errorf("replica asked %d times, want 1", n)
+	}
+
+	// The latencies of both requests drive the hedge delay
+	if n := g.hedge.cur.Snapshot().Count; n != 2 {
+		t.Errorf("hedger observed %d requests, want 2", n)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	h := newHedger(HedgeOptions{Delay: time.Millisecond, Percentile: 0.5})
+	now := time.Now().UnixNano()
+	for i := 0; i < minHedgeSamples-1; i++ {
+		h.observe(40*time.Millisecond, now)
+	}
+	if d := h.delay(now); d != time.Millisecond {
+		t.Errorf("delay with too few samples = %v, want the Delay", d)
+	}
+	h.observe(40*time.Millisecond, now)
+	if d := h.delay(now); d <= 25*time.Millisecond || d > 50*time.Millisecond {
+		t.Errorf("delay = %v, want the median latency bucket", d)
+	}
+
+	// Latencies are forgotten after two minutes
+	if d := h.delay(now + int64(90*time.Second)); d <= 25*time.Millisecond {
+		t.Errorf("delay after a minute = %v, want the latency of the previous minute", d)
+	}
+	if d := h.delay(now + int64(3*time.Minute)); d != time.Millisecond {
+		t.Errorf("delay after 3 minutes = %v, want the Delay", d)
+	}
+
+	if newHedger(HedgeOptions{}) != nil {
+		t.Error("expected hedging to be disabled by default")
+	}
+}
+
+// zonedPeers owns every key on all of its peers, in order, and places them
+// in zones.
+type zonedPeers struct {
+	fakePeers
+	self  string
+	zones map[ProtoGetter]string
+}
+
+func (p zonedPeers) PickPeers(key string) []ProtoGetter {
+	return append([]ProtoGetter(nil), p.fakePeers...)
+}
+
+func (p zonedPeers) Self() PeerInfo {
+	return PeerInfo{Zone: p.self}
+}
+
+func (p zonedPeers) PeerInfo(peer ProtoGetter) PeerInfo {
+	return PeerInfo{Zone: p.zones[peer]}
+}
+
+func TestZoneAwareReads(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		self     string
+		wantNear int
+		wantFar  int
+	}{
+		{"same_zone_first", "a", 1, 0},
+		{"unknown_zone", "", 0, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			far, near := &fakePeer{}, &fakePeer{}
+			g := NewRegistry().NewGroupWithOptions("TestZoneAwareReads-group", GetterFunc(func(_ context.Context, key string, dest Sink) error {
+				t.Fatalf("unexpected local load of %q", key)
+				return nil
+			}), GroupOptions{
+				CacheBytes: 1 << 20,
+				PeerPicker: zonedPeers{
+					fakePeers: fakePeers{far, near},
+					self:      tt.self,
+					zones:     map[ProtoGetter]string{far: "b", near: "a"},
+				},
+			})
+			var s string
+			if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+			if near.hits != tt.wantNear || far.hits != tt.wantFar {
+				t.Errorf("near peer hit %d times and far peer %d times, want %d and %d",
+					near.hits, far.hits, tt.wantNear, tt.wantFar)
+			}
+
+			// Failing over to the other zone
+			near.fail = true
+			results := g.GetMany(dummyCtx, []string{"other"}, func(string) Sink { return StringSink(&s) })
+			if results[0].Err != nil {
+				t.Fatal(results[0].Err)
+			}
+			if s != "got:other" {
+				t.Errorf("GetMany got %q, want %q", s, "got:other")
+			}
+		})
+	}
+}
+
+func TestCrossZoneHotCacheAdmission(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		zone    string
+		opts    HotCacheOptions
+		wantHot bool
+	}{
+		{"same_zone", "a", HotCacheOptions{MinQPS: 4}, false},
+		{"cross_zone", "b", HotCacheOptions{MinQPS: 4}, true},
+		{"cross_zone_factor", "b", HotCacheOptions{MinQPS: 4, CrossZoneFactor: 1}, false},
+		{"unknown_zone", "", HotCacheOptions{MinQPS: 4}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := &fakePeer{qps: 2}
+			g := NewRegistry().NewGroupWithOptions("TestCrossZoneHotCacheAdmission-group", GetterFunc(func(_ context.Context, key string, dest Sink) error {
+				t.Fatalf("unexpected local load of %q", key)
+				return nil
+			}), GroupOptions{
+				CacheBytes: 1 << 20,
+				PeerPicker: zonedPeers{
+					fakePeers: fakePeers{peer},
+					self:      "a",
+					zones:     map[ProtoGetter]string{peer: tt.zone},
+				},
+				HotCache: tt.opts,
+			})
+			var s string
+			if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+			if hot := g.CacheStats(HotCache).Items == 1; hot != tt.wantHot {
+				t.Errorf("value in hot cache = %v, want %v", hot, tt.wantHot)
+			}
+		})
+	}
+}
+
+func TestRefreshPanic(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	var loads AtomicInt
+	g := newGroup("TestRefreshPanic-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		if loads.Get() > 0 {
+			panic("getter bug")
+		}
+		loads.Add(1)
+		return dest.SetString("value", clock.Now()+int64(time.Minute))
+	}), NoPeers{}, clock)
+	g.SetRefreshOptions(RefreshOptions{SoftTTL: time.Second})
+
+	var s string
+	for i := 0; i < 2; i++ {
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(2 * time.Second)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for g.Stats.RefreshErrs.Get() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting on the failed refresh")
 		}
+		time.Sleep(time.Millisecond)
 	}
 }
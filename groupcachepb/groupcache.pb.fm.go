@@ -150,6 +150,14 @@ func (m *GetResponse) Size() int {
 	if m.Expire != 0 {
 		sz += csproto.SizeOfTagKey(3) + csproto.SizeOfVarint(uint64(m.Expire))
 	}
+	// Status (enum,optional)
+	if m.Status != 0 {
+		sz += csproto.SizeOfTagKey(4) + csproto.SizeOfVarint(uint64(m.Status))
+	}
+	// Error (string,optional)
+	if l = len(m.Error); l > 0 {
+		sz += csproto.SizeOfTagKey(5) + csproto.SizeOfVarint(uint64(l)) + l
+	}
 	// cache the size so it can be re-used in Marshal()/MarshalTo()
 	atomic.StoreInt32(&m.sizeCache, int32(sz))
 	return sz
@@ -189,6 +197,14 @@ func (m *GetResponse) MarshalTo(dest []byte) error {
 	if m.Expire != 0 {
 		enc.EncodeInt64(3, m.Expire)
 	}
+	// Status (4,enum,optional)
+	if m.Status != 0 {
+		enc.EncodeInt32(4, int32(m.Status))
+	}
+	// Error (5,string,optional)
+	if len(m.Error) > 0 {
+		enc.EncodeString(5, m.Error)
+	}
 	return nil
 }
 
@@ -237,6 +253,24 @@ func (m *GetResponse) Unmarshal(p []byte) error {
 			} else {
 				m.Expire = v
 			}
+		case 4: // Status (enum,optional)
+			if wt != csproto.WireTypeVarint {
+				return fmt.Errorf("incorrect wire type %v for tag field 'status' (tag=4), expected 0 (varint)", wt)
+			}
+			if v, err := dec.DecodeInt32(); err != nil {
+				return fmt.Errorf("unable to decode int32 enum value for field 'status' (tag=4): %w", err)
+			} else {
+				m.Status = Status(v)
+			}
+		case 5: // Error (string,optional)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'error' (tag=5), expected 2 (length-delimited)", wt)
+			}
+			if s, err := dec.DecodeString(); err != nil {
+				return fmt.Errorf("unable to decode string value for field 'error' (tag=5): %w", err)
+			} else {
+				m.Error = s
+			}
 
 		default:
 			if skipped, err := dec.Skip(tag, wt); err != nil {
@@ -396,3 +430,361 @@ func (m *SetRequest) Unmarshal(p []byte) error {
 	}
 	return nil
 }
+
+//------------------------------------------------------------------------------
+// Custom Protobuf size/marshal/unmarshal code for GetManyRequest
+
+// Size calculates and returns the size, in bytes, required to hold the contents of m using the Protobuf
+// binary encoding.
+func (m *GetManyRequest) Size() int {
+	// nil message is always 0 bytes
+	if m == nil {
+		return 0
+	}
+	// return cached size, if present
+	if csz := int(atomic.LoadInt32(&m.sizeCache)); csz > 0 {
+		return csz
+	}
+	// calculate and cache
+	var sz, l int
+	_ = l // avoid unused variable
+
+	// Group (string,optional)
+	if l = len(m.Group); l > 0 {
+		sz += csproto.SizeOfTagKey(1) + csproto.SizeOfVarint(uint64(l)) + l
+	}
+	// Keys (string,repeated)
+	for _, sv := range m.Keys {
+		l = len(sv)
+		sz += csproto.SizeOfTagKey(2) + csproto.SizeOfVarint(uint64(l)) + l
+	}
+	// cache the size so it can be re-used in Marshal()/MarshalTo()
+	atomic.StoreInt32(&m.sizeCache, int32(sz))
+	return sz
+}
+
+// Marshal converts the contents of m to the Protobuf binary encoding and returns the result or an error.
+func (m *GetManyRequest) Marshal() ([]byte, error) {
+	siz := m.Size()
+	buf := make([]byte, siz)
+	err := m.MarshalTo(buf)
+	return buf, err
+}
+
+// MarshalTo converts the contents of m to the Protobuf binary encoding and writes the result to dest.
+func (m *GetManyRequest) MarshalTo(dest []byte) error {
+	var (
+		enc    = csproto.NewEncoder(dest)
+		buf    []byte
+		err    error
+		extVal interface{}
+	)
+	// ensure no unused variables
+	_ = enc
+	_ = buf
+	_ = err
+	_ = extVal
+
+	// Group (1,string,optional)
+	if len(m.Group) > 0 {
+		enc.EncodeString(1, m.Group)
+	}
+	// Keys (2,string,repeated)
+	for _, val := range m.Keys {
+		enc.EncodeString(2, val)
+	}
+	return nil
+}
+
+// Unmarshal decodes a binary encoded Protobuf message from p and populates m with the result.
+func (m *GetManyRequest) Unmarshal(p []byte) error {
+	if len(p) == 0 {
+		return fmt.Errorf("cannot unmarshal from an empty buffer")
+	}
+	// clear any existing data
+	m.Reset()
+	dec := csproto.NewDecoder(p)
+	// enable faster, but unsafe, string decoding
+	dec.SetMode(csproto.DecoderModeFast)
+	for dec.More() {
+		tag, wt, err := dec.DecodeTag()
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case 1: // Group (string,optional)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'group' (tag=1), expected 2 (length-delimited)", wt)
+			}
+			if s, err := dec.DecodeString(); err != nil {
+				return fmt.Errorf("unable to decode string value for field 'group' (tag=1): %w", err)
+			} else {
+				m.Group = s
+			}
+
+		case 2: // Keys (string,repeated)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'keys' (tag=2), expected 2 (length-delimited)", wt)
+			}
+			if s, err := dec.DecodeString(); err != nil {
+				return fmt.Errorf("unable to decode string value for field 'keys' (tag=2): %w", err)
+			} else {
+				m.Keys = append(m.Keys, s)
+			}
+
+		default:
+			if skipped, err := dec.Skip(tag, wt); err != nil {
+				return fmt.Errorf("invalid operation skipping tag %v: %w", tag, err)
+			} else {
+				m.unknownFields = append(m.unknownFields, skipped...)
+			}
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// Custom Protobuf size/marshal/unmarshal code for GetManyResult
+
+// Size calculates and returns the size, in bytes, required to hold the contents of m using the Protobuf
+// binary encoding.
+func (m *GetManyResult) Size() int {
+	// nil message is always 0 bytes
+	if m == nil {
+		return 0
+	}
+	// return cached size, if present
+	if csz := int(atomic.LoadInt32(&m.sizeCache)); csz > 0 {
+		return csz
+	}
+	// calculate and cache
+	var sz, l int
+	_ = l // avoid unused variable
+
+	// Key (string,optional)
+	if l = len(m.Key); l > 0 {
+		sz += csproto.SizeOfTagKey(1) + csproto.SizeOfVarint(uint64(l)) + l
+	}
+	// Response (message,optional)
+	if m.Response != nil {
+		l = csproto.Size(m.Response)
+		sz += csproto.SizeOfTagKey(2) + csproto.SizeOfVarint(uint64(l)) + l
+	}
+	// Error (string,optional)
+	if l = len(m.Error); l > 0 {
+		sz += csproto.SizeOfTagKey(3) + csproto.SizeOfVarint(uint64(l)) + l
+	}
+	// Status (enum,optional)
+	if m.Status != 0 {
+		sz += csproto.SizeOfTagKey(4) + csproto.SizeOfVarint(uint64(m.Status))
+	}
+	// cache the size so it can be re-used in Marshal()/MarshalTo()
+	atomic.StoreInt32(&m.sizeCache, int32(sz))
+	return sz
+}
+
+// Marshal converts the contents of m to the Protobuf binary encoding and returns the result or an error.
+func (m *GetManyResult) Marshal() ([]byte, error) {
+	siz := m.Size()
+	buf := make([]byte, siz)
+	err := m.MarshalTo(buf)
+	return buf, err
+}
+
+// MarshalTo converts the contents of m to the Protobuf binary encoding and writes the result to dest.
+func (m *GetManyResult) MarshalTo(dest []byte) error {
+	var (
+		enc    = csproto.NewEncoder(dest)
+		buf    []byte
+		err    error
+		extVal interface{}
+	)
+	// ensure no unused variables
+	_ = enc
+	_ = buf
+	_ = err
+	_ = extVal
+
+	// Key (1,string,optional)
+	if len(m.Key) > 0 {
+		enc.EncodeString(1, m.Key)
+	}
+	// Response (2,message,optional)
+	if m.Response != nil {
+		if err = enc.EncodeNested(2, m.Response); err != nil {
+			return fmt.Errorf("unable to encode message data for field 'response' (tag=2): %w", err)
+		}
+	}
+	// Error (3,string,optional)
+	if len(m.Error) > 0 {
+		enc.EncodeString(3, m.Error)
+	}
+	// Status (4,enum,optional)
+	if m.Status != 0 {
+		enc.EncodeInt32(4, int32(m.Status))
+	}
+	return nil
+}
+
+// Unmarshal decodes a binary encoded Protobuf message from p and populates m with the result.
+func (m *GetManyResult) Unmarshal(p []byte) error {
+	if len(p) == 0 {
+		return fmt.Errorf("cannot unmarshal from an empty buffer")
+	}
+	// clear any existing data
+	m.Reset()
+	dec := csproto.NewDecoder(p)
+	// enable faster, but unsafe, string decoding
+	dec.SetMode(csproto.DecoderModeFast)
+	for dec.More() {
+		tag, wt, err := dec.DecodeTag()
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case 1: // Key (string,optional)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'key' (tag=1), expected 2 (length-delimited)", wt)
+			}
+			if s, err := dec.DecodeString(); err != nil {
+				return fmt.Errorf("unable to decode string value for field 'key' (tag=1): %w", err)
+			} else {
+				m.Key = s
+			}
+
+		case 2: // Response (message,optional)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'response' (tag=2), expected 2 (length-delimited)", wt)
+			}
+			var mm GetResponse
+			if err = dec.DecodeNested(&mm); err != nil {
+				return fmt.Errorf("unable to decode message value for field 'response' (tag=2): %w", err)
+			}
+			m.Response = &mm
+		case 3: // Error (string,optional)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'error' (tag=3), expected 2 (length-delimited)", wt)
+			}
+			if s, err := dec.DecodeString(); err != nil {
+				return fmt.Errorf("unable to decode string value for field 'error' (tag=3): %w", err)
+			} else {
+				m.Error = s
+			}
+
+		case 4: // Status (enum,optional)
+			if wt != csproto.WireTypeVarint {
+				return fmt.Errorf("incorrect wire type %v for tag field 'status' (tag=4), expected 0 (varint)", wt)
+			}
+			if v, err := dec.DecodeInt32(); err != nil {
+				return fmt.Errorf("unable to decode int32 enum value for field 'status' (tag=4): %w", err)
+			} else {
+				m.Status = Status(v)
+			}
+
+		default:
+			if skipped, err := dec.Skip(tag, wt); err != nil {
+				return fmt.Errorf("invalid operation skipping tag %v: %w", tag, err)
+			} else {
+				m.unknownFields = append(m.unknownFields, skipped...)
+			}
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// Custom Protobuf size/marshal/unmarshal code for GetManyResponse
+
+// Size calculates and returns the size, in bytes, required to hold the contents of m using the Protobuf
+// binary encoding.
+func (m *GetManyResponse) Size() int {
+	// nil message is always 0 bytes
+	if m == nil {
+		return 0
+	}
+	// return cached size, if present
+	if csz := int(atomic.LoadInt32(&m.sizeCache)); csz > 0 {
+		return csz
+	}
+	// calculate and cache
+	var sz, l int
+	_ = l // avoid unused variable
+
+	// Results (message,repeated)
+	for _, val := range m.Results {
+		if l = csproto.Size(val); l > 0 {
+			sz += csproto.SizeOfTagKey(1) + csproto.SizeOfVarint(uint64(l)) + l
+		}
+	}
+	// cache the size so it can be re-used in Marshal()/MarshalTo()
+	atomic.StoreInt32(&m.sizeCache, int32(sz))
+	return sz
+}
+
+// Marshal converts the contents of m to the Protobuf binary encoding and returns the result or an error.
+func (m *GetManyResponse) Marshal() ([]byte, error) {
+	siz := m.Size()
+	buf := make([]byte, siz)
+	err := m.MarshalTo(buf)
+	return buf, err
+}
+
+// MarshalTo converts the contents of m to the Protobuf binary encoding and writes the result to dest.
+func (m *GetManyResponse) MarshalTo(dest []byte) error {
+	var (
+		enc    = csproto.NewEncoder(dest)
+		buf    []byte
+		err    error
+		extVal interface{}
+	)
+	// ensure no unused variables
+	_ = enc
+	_ = buf
+	_ = err
+	_ = extVal
+
+	// Results (1,message,repeated)
+	for _, mm := range m.Results {
+		if err = enc.EncodeNested(1, mm); err != nil {
+			return fmt.Errorf("unable to encode message data for field 'results' (tag=1): %w", err)
+		}
+	}
+	return nil
+}
+
+// Unmarshal decodes a binary encoded Protobuf message from p and populates m with the result.
+func (m *GetManyResponse) Unmarshal(p []byte) error {
+	if len(p) == 0 {
+		return fmt.Errorf("cannot unmarshal from an empty buffer")
+	}
+	// clear any existing data
+	m.Reset()
+	dec := csproto.NewDecoder(p)
+	// enable faster, but unsafe, string decoding
+	dec.SetMode(csproto.DecoderModeFast)
+	for dec.More() {
+		tag, wt, err := dec.DecodeTag()
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case 1: // Results (message,repeated)
+			if wt != csproto.WireTypeLengthDelimited {
+				return fmt.Errorf("incorrect wire type %v for field 'results' (tag=1), expected 2 (length-delimited)", wt)
+			}
+			var mm GetManyResult
+			if err = dec.DecodeNested(&mm); err != nil {
+				return fmt.Errorf("unable to decode message value for field 'results' (tag=1): %w", err)
+			}
+			m.Results = append(m.Results, &mm)
+
+		default:
+			if skipped, err := dec.Skip(tag, wt); err != nil {
+				return fmt.Errorf("invalid operation skipping tag %v: %w", tag, err)
+			} else {
+				m.unknownFields = append(m.unknownFields, skipped...)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+// Package groupcachetest provides an in-process cluster of groupcache
+// nodes for use in tests.
+//
+// Each node has its own groupcache.Registry and HTTPPool, and the nodes
+// talk to each other either through httptest servers or through an
+// in-memory transport which calls the pools' handlers directly. All the
+// groups of the cluster share a fake Clock, so expiry can be tested without
+// sleeping.
+package groupcachetest
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+	"github.com/mailgun/groupcache/v2/consistenthash"
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+// Transport selects how the nodes of a Cluster reach each other.
+type Transport int
+
+const (
+	// HTTP serves each node with an httptest server on the loopback interface.
+	HTTP Transport = iota
+
+	// InMemory passes requests directly to the handler of the node,
+	// without using the network.
+	InMemory
+)
+
+// Options are the configurations of a Cluster.
+type Options struct {
+	// Nodes is the number of nodes in the cluster.
+	// If blank, it defaults to 3.
+	Nodes int
+
+	// Transport selects how the nodes reach each other.
+	// If blank, it defaults to HTTP.
+	Transport Transport
+
+	// Replicas and HashFn configure the consistent hash of the nodes,
+	// see groupcache.HTTPPoolOptions.
+	Replicas int
+	HashFn   consistenthash.Hash
+
+	// Replication is the number of nodes which own each key,
+	// see groupcache.HTTPPoolOptions.
+	Replication int
+
+	// HealthCheck optionally enables the ejection of unhealthy nodes,
+	// see groupcache.HTTPPoolOptions. If its Timer is nil, the cluster's
+	// Clock is used.
+	HealthCheck *groupcache.HealthCheckOptions
+}
+
+// A Cluster is a set of groupcache nodes running in the current process.
+type Cluster struct {
+	// Clock is the timer of every group in the cluster.
+	Clock *timer.Fake
+
+	t      testing.TB
+	opts   Options
+	nodes  []*Node
+	byHost map[string]*Node
+
+	mu     sync.Mutex
+	groups []groupSpec
+	loads  map[string][]int // nodes which loaded each key, in order
+}
+
+type groupSpec struct {
+	name       string
+	cacheBytes int64
+	getter     groupcache.Getter
+}
+
+// A Node is a member of a Cluster. Killing and restarting the node
+// replaces its Registry, Pool and groups.
+type Node struct {
+	// Index is the position of the node in the cluster.
+	Index int
+
+	// URL is the base URL of the node, as used by its peers.
+	URL string
+
+	c *Cluster
+
+	mu       sync.Mutex
+	alive    bool
+	registry *groupcache.Registry
+	pool     *groupcache.HTTPPool
+	server   *httptest.Server
+	listener net.Listener // of the HTTP transport, until the node starts
+}
+
+// NewCluster starts a cluster. The cluster is closed when the test
+// and all its subtests complete.
+func NewCluster(t testing.TB, o Options) *Cluster {
+	if o.Nodes == 0 {
+		o.Nodes = 3
+	}
+	c := &Cluster{
+		Clock:  timer.NewFake(time.Now()),
+		t:      t,
+		opts:   o,
+		byHost: make(map[string]*Node),
+		loads:  make(map[string][]int),
+	}
+	for i := 0; i < o.Nodes; i++ {
+		n := &Node{Index: i, c: c}
+		switch o.Transport {
+		case HTTP:
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("groupcachetest: failed to listen: %v", err)
+			}
+			n.URL = "http://" + l.Addr().String()
+			n.listener = l
+		case InMemory:
+			n.URL = fmt.Sprintf("http://node%d", i)
+		default:
+			t.Fatalf("groupcachetest: unknown transport %d", o.Transport)
+		}
+		c.nodes = append(c.nodes, n)
+		c.byHost[n.URL[len("http://"):]] = n
+	}
+	for _, n := range c.nodes {
+		n.start()
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// Close stops every node of the cluster.
+func (c *Cluster) Close() {
+	for _, n := range c.nodes {
+		n.Kill()
+	}
+}
+
+// Node returns the i-th node of the cluster.
+func (c *Cluster) Node(i int) *Node {
+	return c.nodes[i]
+}
+
+// Nodes returns the nodes of the cluster.
+func (c *Cluster) Nodes() []*Node {
+	return c.nodes
+}
+
+// NewGroup creates a group named name on every node of the cluster, and
+// on nodes which are restarted later. Loads of keys by getter are
+// recorded, see LoadedBy.
+func (c *Cluster) NewGroup(name string, cacheBytes int64, getter groupcache.Getter) {
+	c.mu.Lock()
+	c.groups = append(c.groups, groupSpec{name: name, cacheBytes: cacheBytes, getter: getter})
+	c.mu.Unlock()
+
+	for _, n := range c.nodes {
+		n.mu.Lock()
+		if n.alive {
+			n.newGroup(name, cacheBytes, getter)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// Owner returns the index of the node which owns key.
+func (c *Cluster) Owner(key string) int {
+	for _, n := range c.nodes {
+		if _, remote := n.Pool().PickPeer(key); !remote {
+			return n.Index
+		}
+	}
+	c.t.Fatalf("groupcachetest: no node owns key %q", key)
+	return -1
+}
+
+// Owners returns the indexes of the nodes which own key, in the order in
+// which they are tried.
+func (c *Cluster) Owners(key string) []int {
+	owners := make(map[int]int) // owner at each position
+	for _, n := range c.nodes {
+		for pos, peer := range n.Pool().PickPeers(key) {
+			if peer == nil {
+				owners[pos] = n.Index
+			}
+		}
+	}
+	res := make([]int, len(owners))
+	for pos, i := range owners {
+		res[pos] = i
+	}
+	return res
+}
+
+// KeyOwnedBy returns a key which is owned by the i-th node.
+func (c *Cluster) KeyOwnedBy(i int) string {
+	for j := 0; j < 100000; j++ {
+		key := fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprint(j))))
+		if c.Owner(key) == i {
+			return key
+		}
+	}
+	c.t.Fatalf("groupcachetest: no key found for node %d", i)
+	return ""
+}
+
+// LoadedBy returns the indexes of the nodes whose getter loaded key,
+// in the order of the loads.
+func (c *Cluster) LoadedBy(key string) []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.loads[key]...)
+}
+
+// ResetLoads forgets the loads recorded so far.
+func (c *Cluster) ResetLoads() {
+	c.mu.Lock()
+	c.loads = make(map[string][]int)
+	c.mu.Unlock()
+}
+
+// AssertLoadedBy fails the test unless key was loaded exactly once since
+// the loads were last reset, by the i-th node.
+func (c *Cluster) AssertLoadedBy(t testing.TB, key string, i int) {
+	t.Helper()
+	if loads := c.LoadedBy(key); len(loads) != 1 || loads[0] != i {
+		t.Errorf("expected key %q to be loaded once by node %d, loaded by %v", key, i, loads)
+	}
+}
+
+// AssertNotLoaded fails the test if key was loaded since the loads were
+// last reset.
+func (c *Cluster) AssertNotLoaded(t testing.TB, key string) {
+	t.Helper()
+	if loads := c.LoadedBy(key); len(loads) != 0 {
+		t.Errorf("expected key %q not to be loaded, loaded by %v", key, loads)
+	}
+}
+
+func (c *Cluster) recordLoad(key string, i int) {
+	c.mu.Lock()
+	c.loads[key] = append(c.loads[key], i)
+	c.mu.Unlock()
+}
+
+// memTransport is the http.RoundTripper of the InMemory transport.
+type memTransport struct {
+	c *Cluster
+}
+
+func (t memTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	n, ok := t.c.byHost[r.URL.Host]
+	if !ok {
+		return nil, fmt.Errorf("groupcachetest: unknown host %q", r.URL.Host)
+	}
+	n.mu.Lock()
+	alive, pool := n.alive, n.pool
+	n.mu.Unlock()
+	if !alive {
+		return nil, errors.New("groupcachetest: connection refused")
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, r)
+	return w.Result(), nil
+}
+
+// Registry returns the current registry of the node.
+func (n *Node) Registry() *groupcache.Registry {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.registry
+}
+
+// Pool returns the current pool of the node.
+func (n *Node) Pool() *groupcache.HTTPPool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.pool
+}
+
+// Group returns the named group of the node, or nil if the node is not
+// alive or has no such group.
+func (n *Node) Group(name string) *groupcache.Group {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.alive {
+		return nil
+	}
+	return n.registry.GetGroup(name)
+}
+
+// Alive reports whether the node is serving requests.
+func (n *Node) Alive() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.alive
+}
+
+// Kill stops the node. Requests from its peers fail until it is restarted.
+func (n *Node) Kill() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.alive {
+		return
+	}
+	n.alive = false
+	if n.server != nil {
+		n.server.Close()
+		n.server = nil
+	}
+	n.pool.Close()
+}
+
+// Restart restarts a killed node with empty caches, at the same URL.
+// With the HTTP transport, the test is skipped if another process took
+// the port of the node while it was down.
+func (n *Node) Restart() {
+	n.Kill()
+	n.start()
+}
+
+func (n *Node) start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	c := n.c
+	n.registry = groupcache.NewRegistry()
+	opts := &groupcache.HTTPPoolOptions{
+		Replicas:    c.opts.Replicas,
+		HashFn:      c.opts.HashFn,
+		Replication: c.opts.Replication,
+	}
+	if c.opts.HealthCheck != nil {
+		hc := *c.opts.HealthCheck
+		if hc.Timer == nil {
+			hc.Timer = c.Clock
+		}
+		opts.HealthCheck = &hc
+	}
+	if c.opts.Transport == InMemory {
+		opts.Transport = func(context.Context) http.RoundTripper { return memTransport{c} }
+	}
+	n.pool = n.registry.NewHTTPPoolOpts(n.URL, opts)
+	peers := make([]string, len(c.nodes))
+	for i, peer := range c.nodes {
+		peers[i] = peer.URL
+	}
+	n.pool.Set(peers...)
+
+	c.mu.Lock()
+	for _, g := range c.groups {
+		n.newGroup(g.name, g.cacheBytes, g.getter)
+	}
+	c.mu.Unlock()
+
+	if c.opts.Transport == HTTP {
+		l := n.listener
+		n.listener = nil
+		if l == nil {
+			l = n.relisten()
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/_groupcache/", n.pool)
+		n.server = &httptest.Server{
+			Listener: l,
+			Config:   &http.Server{Handler: mux},
+		}
+		n.server.Start()
+	}
+	n.alive = true
+}
+
+// relisten listens again on the address of a restarted node. The port
+// was released when the node was killed, so retry for a short while in
+// case it is not free yet, and skip the test if another process took it.
+func (n *Node) relisten() net.Listener {
+	var err error
+	for i := 0; i < 10; i++ {
+		var l net.Listener
+		if l, err = net.Listen("tcp", n.URL[len("http://"):]); err == nil {
+			return l
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.c.t.Skipf("groupcachetest: failed to listen again for node %d: %v", n.Index, err)
+	return nil
+}
+
+func (n *Node) newGroup(name string, cacheBytes int64, getter groupcache.Getter) {
+	i := n.Index
+	n.registry.NewGroup(name, cacheBytes, groupcache.GetterFunc(func(ctx context.Context, key string, dest groupcache.Sink) error {
+		n.c.recordLoad(key, i)
+		return getter.Get(ctx, key, dest)
+	}), n.c.Clock)
+}
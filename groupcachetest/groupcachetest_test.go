@@ -0,0 +1,191 @@
+package groupcachetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCluster(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		transport Transport
+	}{
+		{"http", HTTP},
+		{"in_memory", InMemory},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCluster(t, Options{Transport: tt.transport})
+			c.NewGroup("test", 1<<20, groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+				return dest.SetString("got:"+key, c.Clock.Now()+int64(time.Minute))
+			}))
+			get := func(node int, key string) {
+				t.Helper()
+				var value string
+				if err := c.Node(node).Group("test").Get(context.Background(), key, groupcache.StringSink(&value)); err != nil {
+					t.Fatal(err)
+				}
+				if value != "got:"+key {
+					t.Errorf("Get(%q) = %q, want %q", key, value, "got:"+key)
+				}
+			}
+
+			// Keys are loaded by their owner, whichever node is asked
+			key := c.KeyOwnedBy(1)
+			for i := range c.Nodes() {
+				get(i, key)
+			}
+			c.AssertLoadedBy(t, key, 1)
+
+			// Remove clears the key from every node
+			c.ResetLoads()
+			if err := c.Node(2).Group("test").Remove(context.Background(), key); err != nil {
+				t.Fatal(err)
+			}
+			get(0, key)
+			c.AssertLoadedBy(t, key, 1)
+
+			// Expired values are loaded again
+			c.ResetLoads()
+			c.Clock.Advance(2 * time.Minute)
+			get(1, key)
+			c.AssertLoadedBy(t, key, 1)
+
+			// Nodes load keys themselves while their owner is down
+			c.ResetLoads()
+			c.Node(1).Kill()
+			other := key + "-other"
+			for c.Owner(other) != 1 {
+				other += "-"
+			}
+			get(0, other)
+			c.AssertLoadedBy(t, other, 0)
+
+			// A restarted node starts with empty caches
+			c.ResetLoads()
+			c.Node(1).Restart()
+			get(0, key)
+			c.AssertLoadedBy(t, key, 1)
+		})
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := NewCluster(t, Options{Transport: InMemory, Replication: 2})
+	c.NewGroup("test", 1<<20, groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+		return dest.SetString("got:"+key, 0)
+	}))
+	ctx := context.Background()
+
+	key := c.KeyOwnedBy(0)
+	owners := c.Owners(key)
+	if len(owners) != 2 || owners[0] != 0 {
+		t.Fatalf("Owners(%q) = %v, want 2 owners starting with 0", key, owners)
+	}
+	other := 3 - owners[0] - owners[1]
+
+	// Set writes to both owners
+	if err := c.Node(other).Group("test").Set(ctx, key, []byte("set"), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range owners {
+		if n := c.Node(i).Group("test").CacheStats(groupcache.MainCache).Items; n != 1 {
+			t.Errorf("node %d has %d items in its main cache, want 1", i, n)
+		}
+	}
+
+	// Remove clears both owners
+	if err := c.Node(other).Group("test").Remove(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range owners {
+		if n := c.Node(i).Group("test").CacheStats(groupcache.MainCache).Items; n != 0 {
+			t.Errorf("node %d has %d items in its main cache, want 0", i, n)
+		}
+	}
+
+	// While the first owner is down, the key is loaded once by the
+	// second owner rather than by every node.
+	c.Node(owners[0]).Kill()
+	for i := 0; i < 3; i++ {
+		var value string
+		if err := c.Node(other).Group("test").Get(ctx, key, groupcache.StringSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Node(owners[1]).Group("test").Get(ctx, key, groupcache.StringSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.AssertLoadedBy(t, key, owners[1])
+}
+
+func TestHealthCheck(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	c := NewCluster(t, Options{Transport: InMemory, HealthCheck: &groupcache.HealthCheckOptions{
+		MaxFailures: 2,
+		MinBackoff:  10 * time.Second,
+		Logger:      groupcache.LogrusLogger{Entry: logrus.NewEntry(l)},
+	}})
+	c.NewGroup("test", 1<<20, groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+		return dest.SetString("got:"+key, 0)
+	}))
+
+	var keys []string // owned by node 1
+	for i := 0; len(keys) < 3; i++ {
+		if key := fmt.Sprint("key-", i); c.Owner(key) == 1 {
+			keys = append(keys, key)
+		}
+	}
+	get := func(key string) {
+		t.Helper()
+		var value string
+		if err := c.Node(0).Group("test").Get(context.Background(), key, groupcache.StringSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ejected := func() bool {
+		peer, remote := c.Node(0).Pool().PickPeer(keys[2])
+		return !remote || !strings.HasPrefix(peer.GetURL(), c.Node(1).URL)
+	}
+
+	// After two failed requests, node 0 stops sending node 1's keys to it.
+	c.Node(1).Kill()
+	get(keys[0])
+	if ejected() {
+		t.Fatal("node 1 was ejected after one failure")
+	}
+	get(keys[1])
+	if !ejected() {
+		t.Fatal("node 1 was not ejected")
+	}
+	if !strings.Contains(buf.String(), "ejecting peer") {
+		t.Errorf("ejection was not logged: %q", buf.String())
+	}
+
+	// Node 1 is not re-added while it is down, then re-added once it
+	// recovers, after the doubled backoff.
+	c.Clock.Advance(10 * time.Second)
+	if !ejected() {
+		t.Fatal("node 1 was re-added while down")
+	}
+	c.Node(1).Restart()
+	c.Clock.Advance(10 * time.Second)
+	if !ejected() {
+		t.Fatal("node 1 was re-added before the backoff")
+	}
+	c.Clock.Advance(10 * time.Second)
+	if ejected() {
+		t.Fatal("node 1 was not re-added")
+	}
+	if !strings.Contains(buf.String(), "re-adding peer") {
+		t.Errorf("recovery was not logged: %q", buf.String())
+	}
+}
@@ -0,0 +1,488 @@
+package groupcache
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mailgun/groupcache/v2/consistenthash"
+	pb "github.com/mailgun/groupcache/v2/groupcachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// GRPCPool implements PeerPicker for a pool of gRPC peers.
+type GRPCPool struct {
+	// this peer's address, e.g. "10.0.0.1:8081"
+	self string
+
+	// opts specifies the options.
+	opts GRPCPoolOptions
+
+	// registry holds the groups served by this pool.
+	registry *Registry
+
+	// health ejects unhealthy peers from peers, if enabled.
+	health *healthChecker
+
+	mu          sync.Mutex // guards peers, all, weights, ejected and grpcGetters
+	peers       *consistenthash.Map
+	all         []string               // as given to Set
+	weights     map[string]int         // as given to SetWeighted
+	ejected     map[string]bool        // peers left out of peers
+	grpcGetters map[string]*grpcGetter // keyed by e.g. "10.0.0.2:8081"
+}
+
+// GRPCPoolOptions are the configurations of a GRPCPool.
+type GRPCPoolOptions struct {
+	// Replicas specifies the number of key replicas on the consistent hash.
+	// If blank, it defaults to 50.
+	Replicas int
+
+	// HashFn specifies the hash function of the consistent hash.
+	// If blank, it defaults to fnv1.HashBytes64.
+	HashFn consistenthash.Hash
+
+	// Replication specifies the number of peers which own each key.
+	// Loads try the owners in order, so that a key stays available while
+	// one of its owners is down, and Set and Remove write to all of them.
+	// If blank, it defaults to 1.
+	Replication int
+
+	// DialOptions are used when dialing peers, for example to configure
+	// transport credentials or client interceptors.
+	// If nil, connections are made without transport security.
+	DialOptions []grpc.DialOption
+
+	// CallOptions are applied to every call made to a peer.
+	CallOptions []grpc.CallOption
+
+	// HealthCheck optionally enables the ejection of unhealthy peers from
+	// the consistent hash. By default a peer is healthy if it answers gRPC
+	// calls.
+	HealthCheck *HealthCheckOptions
+}
+
+// NewGRPCPool initializes a gRPC pool of peers, and registers itself as a PeerPicker.
+// For convenience, it also registers the GroupCache service with s.
+// The self argument should be the address other peers use to reach the current
+// server, for example "10.0.0.1:8081".
+func NewGRPCPool(self string, s grpc.ServiceRegistrar) *GRPCPool {
+	p := NewGRPCPoolOpts(self, nil)
+	p.Register(s)
+	return p
+}
+
+// NewGRPCPoolOpts initializes a gRPC pool of peers with the given options.
+// Unlike NewGRPCPool, this function does not register the GroupCache service,
+// that must be done by calling Register.
+func NewGRPCPoolOpts(self string, o *GRPCPoolOptions) *GRPCPool {
+	return DefaultRegistry.NewGRPCPoolOpts(self, o)
+}
+
+// NewGRPCPoolOpts initializes a gRPC pool of peers with the given options
+// and registers it as the registry's PeerPicker. The pool serves the groups
+// of the registry once registered with a gRPC server by calling Register.
+func (r *Registry) NewGRPCPoolOpts(self string, o *GRPCPoolOptions) *GRPCPool {
+	p := newGRPCPool(r, self, o)
+	r.RegisterPeerPicker(func() PeerPicker { return p })
+	return p
+}
+
+func newGRPCPool(r *Registry, self string, o *GRPCPoolOptions) *GRPCPool {
+	p := &GRPCPool{
+		self:        self,
+		registry:    r,
+		grpcGetters: make(map[string]*grpcGetter),
+	}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.DialOptions == nil {
+		p.opts.DialOptions = []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		}
+	}
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	if p.opts.HealthCheck != nil {
+		p.health = newHealthChecker(*p.opts.HealthCheck, p.probe, p.setEjected)
+	}
+	return p
+}
+
+// Register registers the GroupCache service served by this pool with s.
+func (p *GRPCPool) Register(s grpc.ServiceRegistrar) {
+	pb.RegisterGroupCacheServer(s, &grpcServer{registry: p.registry})
+}
+
+// Set updates the pool's list of peers.
+// Each peer value should be a valid gRPC target,
+// for example "10.0.0.2:8081".
+// Connections to peers which remain in the pool are reused, connections
+// to peers no longer in the pool are closed.
+func (p *GRPCPool) Set(peers ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.set(peers, nil)
+}
+
+// SetWeighted updates the pool's list of peers like Set, with the weight
+// of each peer. Peers get shares of the keys proportional to their
+// weights, for example to their memory. A weight below 1 counts as 1.
+func (p *GRPCPool) SetWeighted(peers map[string]int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := make([]string, 0, len(peers))
+	weights := make(map[string]int, len(peers))
+	for peer, w := range peers {
+		all = append(all, peer)
+		weights[peer] = w
+	}
+	sort.Strings(all)
+	return p.set(all, weights)
+}
+
+// set updates the peers of the pool. p.mu must be held.
+func (p *GRPCPool) set(peers []string, weights map[string]int) error {
+	getters := make(map[string]*grpcGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := p.grpcGetters[peer]; ok {
+			getters[peer] = g
+			continue
+		}
+		if peer == p.self {
+			continue
+		}
+		conn, err := grpc.Dial(peer, p.opts.DialOptions...)
+		if err != nil {
+			// Close the connections we just opened, the pool is left unchanged
+			for addr, g := range getters {
+				if _, ok := p.grpcGetters[addr]; !ok {
+					g.conn.Close()
+				}
+			}
+			return err
+		}
+		getters[peer] = &grpcGetter{
+			addr:        peer,
+			conn:        conn,
+			client:      pb.NewGroupCacheClient(conn),
+			callOptions: p.opts.CallOptions,
+			health:      p.health,
+		}
+	}
+	for addr, g := range p.grpcGetters {
+		if _, ok := getters[addr]; !ok {
+			g.conn.Close()
+		}
+	}
+
+	ejected := make(map[string]bool)
+	others := make([]string, 0, len(getters))
+	for addr := range getters {
+		if p.ejected[addr] {
+			ejected[addr] = true
+		}
+		others = append(others, addr)
+	}
+	if p.health != nil {
+		p.health.setPeers(others)
+	}
+	p.all = peers
+	p.weights = weights
+	p.ejected = ejected
+	p.grpcGetters = getters
+	p.updatePeers()
+	return nil
+}
+
+// Close stops the health checks of the peers and closes the connections
+// to them. The pool must not be used after Close.
+func (p *GRPCPool) Close() error {
+	if p.health != nil {
+		p.health.close()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for _, g := range p.grpcGetters {
+		if cerr := g.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	p.grpcGetters = nil
+	return err
+}
+
+// updatePeers rebuilds the consistent hash from the peers which are not
+// ejected. p.mu must be held.
+func (p *GRPCPool) updatePeers() {
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	for _, peer := range p.all {
+		if p.ejected[peer] {
+			continue
+		}
+		if w, ok := p.weights[peer]; ok {
+			p.peers.AddWeighted(peer, w)
+		} else {
+			p.peers.Add(peer)
+		}
+	}
+}
+
+// setEjected ejects peer from the consistent hash, or adds it back.
+func (p *GRPCPool) setEjected(peer string, ejected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.grpcGetters[peer]; !ok {
+		// No longer in the pool
+		return
+	}
+	if ejected {
+		p.ejected[peer] = true
+	} else {
+		delete(p.ejected, peer)
+	}
+	p.updatePeers()
+}
+
+// probe is the default health check of peers, which succeeds if the peer
+// answers a gRPC call.
+func (p *GRPCPool) probe(ctx context.Context, peer string) error {
+	p.mu.Lock()
+	g, ok := p.grpcGetters[peer]
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	// The empty group does not exist, any answer will do
+	_, err := g.client.Get(ctx, &pb.GetRequest{}, g.callOptions...)
+	return unreachable(err)
+}
+
+// GetAll returns all the peers in the pool
+func (p *GRPCPool) GetAll() []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var i int
+	res := make([]ProtoGetter, len(p.grpcGetters))
+	for _, v := range p.grpcGetters {
+		res[i] = v
+		i++
+	}
+	return res
+}
+
+func (p *GRPCPool) PickPeer(key string) (ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != p.self {
+		return p.grpcGetters[peer], true
+	}
+	return nil, false
+}
+
+// PickPeers returns the owners of key, see ReplicatedPeerPicker.
+func (p *GRPCPool) PickPeers(key string) []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return []ProtoGetter{nil}
+	}
+	n := p.opts.Replication
+	if n < 1 {
+		n = 1
+	}
+	owners := p.peers.GetN(key, n)
+	res := make([]ProtoGetter, len(owners))
+	for i, peer := range owners {
+		if peer != p.self {
+			res[i] = p.grpcGetters[peer]
+		}
+	}
+	return res
+}
+
+// PickSuccessor returns the peer which follows the owners of key on the
+// consistent hash, see SuccessorPeerPicker.
+func (p *GRPCPool) PickSuccessor(key string) (ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.opts.Replication
+	if n < 1 {
+		n = 1
+	}
+	peers := p.peers.GetN(key, n+1)
+	if len(peers) <= n || peers[n] == p.self {
+		return nil, false
+	}
+	return p.grpcGetters[peers[n]], true
+}
+
+// grpcServer serves the GroupCache service to peers.
+type grpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	registry *Registry
+}
+
+func (s *grpcServer) group(name string) (*Group, error) {
+	group := s.registry.GetGroup(name)
+	if group == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no such group: %s", name)
+	}
+	group.Stats.ServerRequests.Add(1)
+	return group, nil
+}
+
+func (s *grpcServer) Get(ctx context.Context, in *pb.GetRequest) (*pb.GetResponse, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	res, err := getResponse(ctx, group, in.GetKey())
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return res, nil
+}
+
+func (s *grpcServer) GetMany(ctx context.Context, in *pb.GetManyRequest) (*pb.GetManyResponse, error) {
+	group := s.registry.GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no such group: %s", in.GetGroup())
+	}
+	group.Stats.ServerRequests.Add(int64(len(in.GetKeys())))
+	return group.serveGetMany(ctx, in.GetKeys()), nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*emptypb.Empty, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.localSet(in.GetKey(), in.GetValue(), in.GetExpire(), &group.mainCache)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) Remove(ctx context.Context, in *pb.GetRequest) (*emptypb.Empty, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.localRemove(in.GetKey())
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) Clear(ctx context.Context, in *pb.GetRequest) (*emptypb.Empty, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.localClear()
+	return &emptypb.Empty{}, nil
+}
+
+// grpcCodes maps the status of errors sent to peers to gRPC codes.
+var grpcCodes = map[pb.Status]codes.Code{
+	pb.Status_NOT_FOUND:         codes.NotFound,
+	pb.Status_UNKNOWN:           codes.Unknown,
+	pb.Status_CANCELED:          codes.Canceled,
+	pb.Status_DEADLINE_EXCEEDED: codes.DeadlineExceeded,
+	pb.Status_INTERNAL:          codes.Internal,
+}
+
+// toGRPCError converts err into a gRPC status error.
+func toGRPCError(err error) error {
+	return status.Error(grpcCodes[errorCode(err)], err.Error())
+}
+
+// fromGRPCError converts a gRPC status error returned by a peer into
+// a PeerError. Errors with other codes, such as those raised by the
+// transport, are returned unchanged.
+func fromGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for code, c := range grpcCodes {
+		if c == s.Code() {
+			return &PeerError{Code: code, Message: s.Message()}
+		}
+	}
+	return err
+}
+
+type grpcGetter struct {
+	addr        string
+	conn        *grpc.ClientConn
+	client      pb.GroupCacheClient
+	callOptions []grpc.CallOption
+	health      *healthChecker // if not nil, told whether calls reach the peer
+}
+
+// unreachable returns err if it means a call did not reach the peer.
+func unreachable(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return err
+	}
+	return nil
+}
+
+// result reports the outcome of a call made with ctx to the health
+// checker and converts its error.
+func (g *grpcGetter) result(ctx context.Context, err error) error {
+	g.health.observe(ctx, g.addr, unreachable(err))
+	return fromGRPCError(err)
+}
+
+func (g *grpcGetter) GetURL() string {
+	return g.addr
+}
+
+func (g *grpcGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	res, err := g.client.Get(ctx, in, g.callOptions...)
+	if err := g.result(ctx, err); err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) GetMany(ctx context.Context, in *pb.GetManyRequest, out *pb.GetManyResponse) error {
+	res, err := g.client.GetMany(ctx, in, g.callOptions...)
+	if err := g.result(ctx, err); err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	_, err := g.client.Set(ctx, in, g.callOptions...)
+	return g.result(ctx, err)
+}
+
+func (g *grpcGetter) Remove(ctx context.Context, in *pb.GetRequest) error {
+	_, err := g.client.Remove(ctx, in, g.callOptions...)
+	return g.result(ctx, err)
+}
+
+func (g *grpcGetter) Clear(ctx context.Context, in *pb.GetRequest) error {
+	_, err := g.client.Clear(ctx, in, g.callOptions...)
+	return g.result(ctx, err)
+}
@@ -0,0 +1,133 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/mailgun/groupcache/v2/groupcachepb"
+	"github.com/mailgun/groupcache/v2/timer"
+	"google.golang.org/grpc"
+)
+
+func TestGRPCPool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	server := grpc.NewServer()
+	newGRPCPool(DefaultRegistry, addr, nil).Register(server)
+	go server.Serve(l)
+	defer server.Stop()
+
+	var serverHits int
+	g := newGroup("grpcPoolTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		serverHits++
+		switch key {
+		case "missing":
+			return ErrNotFound
+		case "slow":
+			return context.DeadlineExceeded
+		case "panic":
+			panic("getter panicked")
+		}
+		return dest.SetString("got:"+key, 0)
+	}), NoPeers{}, timer.Default{})
+	g.SetNegativeTTL(time.Minute)
+
+	// Use a dummy self address so every key is owned by the server.
+	client := newGRPCPool(DefaultRegistry, "should-be-ignored", nil)
+	if err := client.Set(addr); err != nil {
+		t.Fatal(err)
+	}
+	peer, ok := client.PickPeer("key")
+	if !ok {
+		t.Fatal("expected PickPeer to nominate the server")
+	}
+	if peer.GetURL() != addr {
+		t.Errorf("GetURL() = %q, want %q", peer.GetURL(), addr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var res pb.GetResponse
+	if err := peer.Get(ctx, &pb.GetRequest{Group: g.Name(), Key: "key"}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "got:key" {
+		t.Errorf("Get() = %q, want %q", res.Value, "got:key")
+	}
+
+	var many pb.GetManyResponse
+	in := &pb.GetManyRequest{Group: g.Name(), Keys: []string{"key", "other"}}
+	if err := peer.(BatchProtoGetter).GetMany(ctx, in, &many); err != nil {
+		t.Fatal(err)
+	}
+	if len(many.Results) != 2 {
+		t.Fatalf("GetMany() returned %d results, want 2", len(many.Results))
+	}
+	for _, r := range many.Results {
+		if want := "got:" + r.Key; string(r.GetResponse().GetValue()) != want {
+			t.Errorf("GetMany(%q) = %q, want %q", r.Key, r.GetResponse().GetValue(), want)
+		}
+	}
+	if serverHits != 2 {
+		t.Errorf("expected serverHits to be '2' got '%d'", serverHits)
+	}
+
+	// Cacheable errors are returned in the response
+	if err := peer.Get(ctx, &pb.GetRequest{Group: g.Name(), Key: "missing"}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != pb.Status_NOT_FOUND {
+		t.Errorf("Get() status = %v, want %v", res.Status, pb.Status_NOT_FOUND)
+	}
+	res.Reset()
+
+	// Other errors are returned with their code
+	err = peer.Get(ctx, &pb.GetRequest{Group: g.Name(), Key: "slow"}, &res)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want it to match %v", err, context.DeadlineExceeded)
+	}
+
+	// A panic of the getter is an internal error
+	err = peer.Get(ctx, &pb.GetRequest{Group: g.Name(), Key: "panic"}, &res)
+	var pe *PeerError
+	if !errors.As(err, &pe) || pe.Code != pb.Status_INTERNAL {
+		t.Errorf("Get() error = %v, want an internal PeerError", err)
+	}
+
+	set := &pb.SetRequest{Group: g.Name(), Key: "key", Value: []byte("set")}
+	if err := peer.Set(ctx, set); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Get(ctx, &pb.GetRequest{Group: g.Name(), Key: "key"}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "set" {
+		t.Errorf("Get() after Set() = %q, want %q", res.Value, "set")
+	}
+
+	if err := peer.Remove(ctx, &pb.GetRequest{Group: g.Name(), Key: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Clear(ctx, &pb.GetRequest{Group: g.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	if items := g.CacheStats(MainCache).Items; items != 0 {
+		t.Errorf("expected main cache to be empty, has %d items", items)
+	}
+
+	err = peer.Get(ctx, &pb.GetRequest{Group: "no-such-group", Key: "key"}, &res)
+	if err == nil {
+		t.Error("expected Get() on an unknown group to fail")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("expected an unknown group not to match ErrNotFound")
+	}
+}
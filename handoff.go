@@ -0,0 +1,133 @@
+package groupcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/consistenthash"
+	pb "github.com/mailgun/groupcache/v2/groupcachepb"
+)
+
+const (
+	defaultHandoffKeysPerSecond = 100
+	defaultHandoffTimeout       = 5 * time.Second
+)
+
+// HandoffOptions configure the transfer of cached values to their new
+// owners when the peers of a pool change. Without handoff, the keys owned
+// by a peer which joins, or is re-added after being ejected, are cold on
+// it, and a rolling deploy causes a wave of loads. With handoff, each
+// process pushes the values in its main cache whose keys have new owners
+// to them with Set requests, and drops the values it no longer owns
+// from its main cache, whether or not they were pushed.
+type HandoffOptions struct {
+	// KeysPerSecond limits the rate at which values are pushed.
+	// If zero, it defaults to 100.
+	KeysPerSecond float64
+
+	// BytesPerSecond optionally limits the rate at which values are
+	// pushed, in bytes of values.
+	BytesPerSecond float64
+
+	// Timeout limits each Set request. If zero, it defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// handoff pushes the values of the groups served by p whose keys have new
+// owners on the consistent hash of next, compared to prev. It stops when
+// ctx is done.
+func (p *HTTPPool) handoff(ctx context.Context, prev consistenthash.Placement, next *httpPeers) {
+	o := p.opts.Handoff
+	n := p.opts.Replication
+	if n < 1 {
+		n = 1
+	}
+
+	// targets returns the new owners of key to which this process pushes
+	// its value, and whether it remains an owner.
+	targets := func(key string) (res []string, owner bool) {
+		prevOwners := prev.GetN(key, n)
+		nextOwners := next.ring.GetN(key, n)
+		owner = containsString(nextOwners, p.self)
+
+		// Only the first previous owner which remains pushes each value
+		for _, peer := range prevOwners {
+			if next.members[peer] {
+				if peer != p.self {
+					return nil, owner
+				}
+				break
+			}
+		}
+		for _, peer := range nextOwners {
+			if peer != p.self && !containsString(prevOwners, peer) {
+				res = append(res, peer)
+			}
+		}
+		return res, owner
+	}
+
+	var wait time.Duration // before the next push
+	for _, g := range p.registry.Groups() {
+		g.peersOnce.Do(g.initPeers)
+		if g.peers != PeerPicker(p) {
+			continue
+		}
+		entries := g.mainCache.entries(func(key string) bool {
+			peers, owner := targets(key)
+			return len(peers) > 0 || !owner
+		})
+		for key, value := range entries {
+			peers, owner := targets(key)
+			// cached errors are not worth a request
+			if value.err == nil && len(peers) > 0 {
+				if err := sleepCtx(ctx, wait); err != nil {
+					return
+				}
+				wait = time.Duration(float64(time.Second) / o.KeysPerSecond)
+				if o.BytesPerSecond > 0 {
+					if d := time.Duration(float64(value.Len()) / o.BytesPerSecond * float64(time.Second)); d > wait {
+						wait = d
+					}
+				}
+				for _, peer := range peers {
+					if err := g.pushTo(ctx, next.getters[peer], key, value, o.Timeout); err != nil {
+						if ctx.Err() != nil {
+							return
+						}
+						g.Stats.HandoffErrs.Add(1)
+						continue
+					}
+					g.Stats.HandoffKeys.Add(1)
+					g.Stats.HandoffBytes.Add(int64(value.Len()))
+				}
+			}
+			if !owner {
+				// Even if a push failed, the value is no longer
+				// authoritative here: the new owner loads it again.
+				g.mainCache.remove(key)
+			}
+		}
+	}
+}
+
+// pushTo sets key to value on peer.
+func (g *Group) pushTo(ctx context.Context, peer *httpGetter, key string, value ByteView, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return peer.Set(ctx, &pb.SetRequest{
+		Group:  g.name,
+		Key:    key,
+		Value:  value.ByteSlice(),
+		Expire: value.e,
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
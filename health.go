@@ -0,0 +1,263 @@
+package groupcache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+const (
+	defaultMaxFailures  = 3
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = time.Minute
+	defaultProbeTimeout = time.Second
+)
+
+// HealthCheckOptions configure the ejection of unhealthy peers from the
+// consistent hash of a pool. Ejected peers no longer own keys, which are
+// owned by the other peers until the ejected peer recovers.
+type HealthCheckOptions struct {
+	// MaxFailures is the number of consecutive failures of requests or
+	// probes to a peer after which it is ejected. Only failures to reach
+	// the peer count, not errors returned by the peer's groups.
+	// If zero, it defaults to 3.
+	MaxFailures int
+
+	// Interval is the time between probes of each peer. If zero, peers
+	// are not probed until they are ejected, and only the requests made
+	// to them are tracked.
+	Interval time.Duration
+
+	// Probe checks whether peer, as given to the pool's Set, is healthy.
+	// If nil, the pool checks that the peer answers requests.
+	Probe func(ctx context.Context, peer string) error
+
+	// MinBackoff is the time after which an ejected peer is probed, and
+	// re-added if the probe succeeds. The time doubles after each failed
+	// probe, up to MaxBackoff. If zero, they default to 1 second and
+	// 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Timer schedules the probes. If nil, it defaults to timer.Default.
+	Timer timer.Timer
+
+	// Logger logs the ejection and recovery of peers. If nil, the logger
+	// set with SetLoggerFromLogger is used.
+	Logger Logger
+}
+
+// healthChecker tracks the health of the peers of a pool and calls
+// setEjected when a peer is ejected or recovers.
+type healthChecker struct {
+	opts       HealthCheckOptions
+	setEjected func(peer string, ejected bool)
+
+	mu       sync.Mutex
+	peers    map[string]*peerHealth
+	stopTick func() // cancels the next tick
+	closed   bool
+}
+
+type peerHealth struct {
+	failures int
+	ejected  bool
+	backoff  time.Duration
+	stop     func() // cancels the next probe of an ejected peer
+}
+
+func newHealthChecker(o HealthCheckOptions, probe func(ctx context.Context, peer string) error,
+	setEjected func(peer string, ejected bool)) *healthChecker {
+	if o.MaxFailures <= 0 {
+		o.MaxFailures = defaultMaxFailures
+	}
+	if o.Probe == nil {
+		o.Probe = probe
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = defaultMaxBackoff
+		if o.MaxBackoff < o.MinBackoff {
+			o.MaxBackoff = o.MinBackoff
+		}
+	}
+	if o.Timer == nil {
+		o.Timer = timer.Default{}
+	}
+	h := &healthChecker{
+		opts:       o,
+		setEjected: setEjected,
+		peers:      make(map[string]*peerHealth),
+	}
+	if o.Interval > 0 {
+		h.mu.Lock()
+		h.scheduleTick()
+		h.mu.Unlock()
+	}
+	return h
+}
+
+// close stops the probes of the peers. Probes in progress finish, but
+// their outcome is ignored.
+func (h *healthChecker) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	if h.stopTick != nil {
+		h.stopTick()
+	}
+	for _, ph := range h.peers {
+		if ph.stop != nil {
+			ph.stop()
+		}
+	}
+}
+
+// setPeers replaces the tracked peers. The state of peers which remain is
+// kept, so ejected peers stay ejected until they recover.
+func (h *healthChecker) setPeers(peers []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keep := make(map[string]*peerHealth, len(peers))
+	for _, peer := range peers {
+		ph, ok := h.peers[peer]
+		if !ok {
+			ph = &peerHealth{}
+		}
+		keep[peer] = ph
+	}
+	for peer, ph := range h.peers {
+		if _, ok := keep[peer]; !ok && ph.stop != nil {
+			ph.stop()
+		}
+	}
+	h.peers = keep
+}
+
+// observe records the outcome of a request to peer made with ctx. It is
+// ignored if the caller gave up, since that says nothing about the peer.
+func (h *healthChecker) observe(ctx context.Context, peer string, err error) {
+	if h == nil || ctx.Err() != nil {
+		return
+	}
+	h.mu.Lock()
+	ph, ok := h.peers[peer]
+	if !ok || ph.ejected || h.closed {
+		h.mu.Unlock()
+		return
+	}
+	if err == nil {
+		ph.failures = 0
+		h.mu.Unlock()
+		return
+	}
+	ph.failures++
+	if ph.failures < h.opts.MaxFailures {
+		h.mu.Unlock()
+		return
+	}
+	ph.ejected = true
+	ph.backoff = h.opts.MinBackoff
+	h.scheduleProbe(peer, ph)
+	h.mu.Unlock()
+
+	if logger := h.log(); logger != nil {
+		logger.Warn().
+			StringField("peer", peer).
+			ErrorField("err", err).
+			Printf("ejecting peer '%s' after %d consecutive failures", peer, h.opts.MaxFailures)
+	}
+	h.setEjected(peer, true)
+}
+
+// scheduleProbe schedules the next probe of an ejected peer. h.mu must
+// be held.
+func (h *healthChecker) scheduleProbe(peer string, ph *peerHealth) {
+	ph.stop = timer.AfterFunc(h.opts.Timer, ph.backoff, func() { h.probeEjected(peer, ph) })
+}
+
+// probeEjected re-adds an ejected peer if it passes a probe, or backs off
+// before probing it again.
+func (h *healthChecker) probeEjected(peer string, ph *peerHealth) {
+	err := h.probe(peer)
+
+	h.mu.Lock()
+	if h.peers[peer] != ph || !ph.ejected || h.closed {
+		// The peer was removed from the pool, or the pool closed
+		h.mu.Unlock()
+		return
+	}
+	if err != nil {
+		ph.backoff *= 2
+		if ph.backoff > h.opts.MaxBackoff {
+			ph.backoff = h.opts.MaxBackoff
+		}
+		h.scheduleProbe(peer, ph)
+		h.mu.Unlock()
+		return
+	}
+	ph.ejected = false
+	ph.failures = 0
+	ph.stop = nil
+	h.mu.Unlock()
+
+	if logger := h.log(); logger != nil {
+		logger.Info().
+			StringField("peer", peer).
+			Printf("re-adding peer '%s' which recovered", peer)
+	}
+	h.setEjected(peer, false)
+}
+
+// scheduleTick schedules the next probes of the peers. h.mu must be held.
+func (h *healthChecker) scheduleTick() {
+	if !h.closed {
+		h.stopTick = timer.AfterFunc(h.opts.Timer, h.opts.Interval, h.tick)
+	}
+}
+
+// tick probes the peers which are not ejected.
+func (h *healthChecker) tick() {
+	h.mu.Lock()
+	var peers []string
+	for peer, ph := range h.peers {
+		if !ph.ejected {
+			peers = append(peers, peer)
+		}
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			h.observe(context.Background(), peer, h.probe(peer))
+		}(peer)
+	}
+	wg.Wait()
+	h.mu.Lock()
+	h.scheduleTick()
+	h.mu.Unlock()
+}
+
+func (h *healthChecker) probe(peer string) error {
+	timeout := h.opts.Interval
+	if timeout <= 0 || timeout > defaultProbeTimeout {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return h.opts.Probe(ctx, peer)
+}
+
+func (h *healthChecker) log() Logger {
+	if h.opts.Logger != nil {
+		return h.opts.Logger
+	}
+	return logger
+}
@@ -0,0 +1,208 @@
+package groupcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minHedgeSamples is the number of requests to owners observed in the
+// last minute or two below which HedgeOptions.Percentile is not used.
+const minHedgeSamples = 20
+
+// HedgeOptions configure hedged requests for values which are not cached.
+// If the owner of a key has not answered after a delay, the next peer on
+// the ring is asked as well, or the value is loaded locally if that peer
+// is this process. The first successful answer is used and the other
+// request is canceled through its context. Hedging trades extra load on
+// the peers for a lower tail latency.
+type HedgeOptions struct {
+	// Delay is the time to wait for the owner before hedging. If
+	// Percentile is set, it is used until enough requests have been
+	// observed, and as a lower bound. If both are zero, requests are not
+	// hedged.
+	Delay time.Duration
+
+	// Percentile, if not zero, sets the delay to this quantile of the
+	// latency of requests to owners over the last minute or two, for
+	// example 0.95 to hedge the slowest 5% of requests.
+	Percentile float64
+}
+
+// hedger decides when requests of a group are hedged.
+type hedger struct {
+	opts HedgeOptions
+
+	mu    sync.Mutex
+	start int64 // start of the current minute
+	cur   *Histogram
+	prev  *Histogram
+}
+
+// newHedger returns a hedger, or nil if o disables hedging.
+func newHedger(o HedgeOptions) *hedger {
+	if o.Delay <= 0 && o.Percentile <= 0 {
+		return nil
+	}
+	return &hedger{opts: o}
+}
+
+// rotate starts a new minute if needed. h.mu must be held.
+func (h *hedger) rotate(now int64) {
+	const minute = int64(time.Minute)
+	switch elapsed := now - h.start; {
+	case h.cur == nil || elapsed >= 2*minute:
+		h.start = now
+		h.cur = &Histogram{}
+		h.prev = nil
+	case elapsed >= minute:
+		h.start = now - elapsed%minute
+		h.prev = h.cur
+		h.cur = &Histogram{}
+	}
+}
+
+// observe records the latency of a request to an owner at now.
+func (h *hedger) observe(d time.Duration, now int64) {
+	if h.opts.Percentile <= 0 {
+		return
+	}
+	h.mu.Lock()
+	h.rotate(now)
+	cur := h.cur
+	h.mu.Unlock()
+	cur.Observe(d)
+}
+
+// delay returns the time to wait for an owner before hedging at now,
+// or 0 if the request should not be hedged.
+func (h *hedger) delay(now int64) time.Duration {
+	if h.opts.Percentile <= 0 {
+		return h.opts.Delay
+	}
+	h.mu.Lock()
+	h.rotate(now)
+	s := h.cur.Snapshot()
+	if h.prev != nil {
+		prev := h.prev.Snapshot()
+		for i := range s.Counts {
+			s.Counts[i] += prev.Counts[i]
+		}
+		s.Count += prev.Count
+	}
+	h.mu.Unlock()
+
+	if s.Count < minHedgeSamples {
+		return h.opts.Delay
+	}
+	if d := s.Quantile(h.opts.Percentile); d > h.opts.Delay {
+		return d
+	}
+	return h.opts.Delay
+}
+
+// hedgeTarget returns the peer asked for key when the first of owners is
+// slow, with nil standing for this process.
+func (g *Group) hedgeTarget(key string, owners []ProtoGetter) ProtoGetter {
+	if len(owners) > 1 {
+		return owners[1]
+	}
+	if sp, ok := g.peers.(SuccessorPeerPicker); ok {
+		if peer, ok := sp.PickSuccessor(key); ok {
+			return peer
+		}
+	}
+	return nil
+}
+
+type hedgeResult struct {
+	value ByteView
+	err   error
+	hedge bool
+	peer  ProtoGetter // of the hedge request, nil standing for this process
+}
+
+// fetchHedged gets key from peer, and from the hedge target as well if
+// peer has not answered within the hedge delay. It returns the first
+// successful answer, or the error of peer if both fail, along with the
+// failed hedge request if one was sent.
+func (g *Group) fetchHedged(ctx context.Context, key string, peer ProtoGetter, owners []ProtoGetter) (ByteView, *hedgeResult, error) {
+	results := make(chan hedgeResult, 2)
+	pctx, pcancel := context.WithCancel(ctx)
+	defer pcancel()
+	go func() {
+		var value ByteView
+		var err error
+		defer func() { results <- hedgeResult{value: value, err: err} }()
+		defer recoverTo(&err)
+		start := time.Now()
+		value, err = g.getFromPeer(pctx, peer, key)
+		if pctx.Err() == nil {
+			g.recordPeerLatency(peer, start)
+			g.hedge.observe(time.Since(start), g.timer.Now())
+		}
+	}()
+
+	delay := g.hedge.delay(g.timer.Now())
+	if delay <= 0 {
+		r := <-results
+		if r.err == nil {
+			g.Stats.PeerLoads.Add(1)
+		}
+		return r.value, nil, r.err
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case r := <-results:
+		if r.err == nil {
+			g.Stats.PeerLoads.Add(1)
+		}
+		return r.value, nil, r.err
+	case <-t.C:
+	}
+
+	g.Stats.HedgesFired.Add(1)
+	hctx, hcancel := context.WithCancel(ctx)
+	defer hcancel()
+	target := g.hedgeTarget(key, owners)
+	go func() {
+		var value ByteView
+		var err error
+		defer func() { results <- hedgeResult{value: value, err: err, hedge: true, peer: target} }()
+		defer recoverTo(&err)
+		if target == nil {
+			// dest belongs to the caller, which may get the owner's value
+			var b []byte
+			value, err = g.loadLocally(hctx, key, AllocatingByteSliceSink(&b))
+		} else {
+			start := time.Now()
+			value, err = g.getFromPeer(hctx, target, key)
+			if hctx.Err() == nil {
+				g.recordPeerLatency(target, start)
+				g.hedge.observe(time.Since(start), g.timer.Now())
+			}
+		}
+	}()
+
+	var ownerErr error
+	var hedge *hedgeResult
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil {
+			if r.hedge {
+				g.Stats.HedgesWon.Add(1)
+			}
+			if !r.hedge || target != nil {
+				g.Stats.PeerLoads.Add(1)
+			}
+			return r.value, nil, nil
+		}
+		if r.hedge {
+			hedge = &r
+		} else {
+			ownerErr = r.err
+		}
+	}
+	return ByteView{}, hedge, ownerErr
+}
@@ -0,0 +1,100 @@
+package groupcache
+
+import (
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of a Histogram.
+var latencyBuckets = [...]time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a latency histogram with fixed buckets, see
+// HistogramSnapshot.Bounds. It is safe for concurrent use.
+type Histogram struct {
+	counts [len(latencyBuckets) + 1]AtomicInt // the last counts overflows
+	sum    AtomicInt                          // in nanoseconds
+}
+
+// Observe records a duration.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// Snapshot returns the current state of the histogram.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: latencyBuckets[:],
+		Counts: make([]int64, len(h.counts)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Get()
+		s.Count += s.Counts[i]
+	}
+	s.Sum = time.Duration(h.sum.Get())
+	return s
+}
+
+// HistogramSnapshot is the state of a Histogram at a point in time.
+type HistogramSnapshot struct {
+	// Bounds are the inclusive upper bounds of the buckets, in
+	// increasing order. They are shared and must not be modified.
+	Bounds []time.Duration
+
+	// Counts are the number of durations observed in each bucket,
+	// with one more count than Bounds for durations above the last
+	// bound. Counts are not cumulative.
+	Counts []int64
+
+	// Count is the total number of durations observed.
+	Count int64
+
+	// Sum is the total of the durations observed.
+	Sum time.Duration
+}
+
+// Quantile returns an estimate of the q-quantile of the observed
+// durations, for example 0.99 for the 99th percentile, interpolated
+// linearly within the bucket. Durations in the last, unbounded bucket
+// are reported as the last bound. It returns 0 if nothing was observed.
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	var seen int64
+	for i, n := range s.Counts {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		if i == len(s.Bounds) {
+			break
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = s.Bounds[i-1]
+		}
+		frac := (rank - float64(seen)) / float64(n)
+		return lower + time.Duration(frac*float64(s.Bounds[i]-lower))
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
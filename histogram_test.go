@@ -0,0 +1,49 @@
+package groupcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if q := h.Snapshot().Quantile(0.5); q != 0 {
+		t.Errorf("empty histogram Quantile(0.5) = %v, want 0", q)
+	}
+
+	for i := 0; i < 90; i++ {
+		h.Observe(time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Observe(40 * time.Millisecond)
+	}
+	h.Observe(time.Minute)
+
+	s := h.Snapshot()
+	if s.Count != 100 {
+		t.Errorf("Count = %d, want 100", s.Count)
+	}
+	if want := 90*time.Millisecond + 9*40*time.Millisecond + time.Minute; s.Sum != want {
+		t.Errorf("Sum = %v, want %v", s.Sum, want)
+	}
+	if len(s.Counts) != len(s.Bounds)+1 {
+		t.Fatalf("got %d counts for %d bounds", len(s.Counts), len(s.Bounds))
+	}
+	if n := s.Counts[len(s.Counts)-1]; n != 1 {
+		t.Errorf("overflow count = %d, want 1", n)
+	}
+
+	tests := []struct {
+		q        float64
+		min, max time.Duration
+	}{
+		{0.5, 500 * time.Microsecond, time.Millisecond},
+		{0.95, 25 * time.Millisecond, 50 * time.Millisecond},
+		{1, 10 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := s.Quantile(tt.q); got < tt.min || got > tt.max {
+			t.Errorf("Quantile(%v) = %v, want between %v and %v", tt.q, got, tt.min, tt.max)
+		}
+	}
+}
@@ -24,11 +24,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mailgun/groupcache/v2/consistenthash"
 	pb "github.com/mailgun/groupcache/v2/groupcachepb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -44,9 +50,47 @@ type HTTPPool struct {
 	// opts specifies the options.
 	opts HTTPPoolOptions
 
-	mu          sync.Mutex // guards peers and httpGetters
-	peers       *consistenthash.Map
-	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	// registry holds the groups served by this pool.
+	registry *Registry
+
+	// health ejects unhealthy peers from peers, if enabled.
+	health *healthChecker
+
+	// peers holds the current *httpPeers. It is replaced rather than
+	// modified, so that picking a peer never waits for an update.
+	peers atomic.Value
+
+	mu      sync.Mutex          // serializes updates of peers, guards all, weights, infos, ejected and handoff
+	all     []string            // the peers of the pool, in the order they were given
+	weights map[string]int      // of the peers given a weight other than 1
+	infos   map[string]PeerInfo // as given to SetPeers
+	ejected map[string]bool     // peers left out of the consistent hash
+
+	// handoffFrom is the consistent hash which the values were last
+	// handed off from, and handoffCancel stops the handoff in progress.
+	handoffFrom   consistenthash.Placement
+	handoffCancel context.CancelFunc
+	handoffDone   chan struct{} // closed when the handoff in progress ends
+
+	closed bool // no handoff is started once closed
+}
+
+// httpPeers is a snapshot of the peers of an HTTPPool. It is not modified
+// once published.
+type httpPeers struct {
+	ring    consistenthash.Placement // of members
+	members map[string]bool          // the peers which are not ejected
+	weights map[string]int           // of the members whose weight is not 1
+	getters map[string]*httpGetter   // keyed by e.g. "http://10.0.0.2:8008"
+	infos   map[string]PeerInfo      // of every peer, keyed like getters
+}
+
+// weight returns the weight of peer on the ring.
+func (h *httpPeers) weight(peer string) int {
+	if w, ok := h.weights[peer]; ok {
+		return w
+	}
+	return 1
 }
 
 // HTTPPoolOptions are the configurations of a HTTPPool.
@@ -63,6 +107,18 @@ type HTTPPoolOptions struct {
 	// If blank, it defaults to crc32.ChecksumIEEE.
 	HashFn consistenthash.Hash
 
+	// Placement optionally returns an empty placement of the keys on the
+	// peers, such as consistenthash.NewJump or NewRendezvous, instead of
+	// a consistent hash with Replicas and HashFn. Every peer of a cluster
+	// must use the same placement.
+	Placement func() consistenthash.Placement
+
+	// Replication specifies the number of peers which own each key.
+	// Loads try the owners in order, so that a key stays available while
+	// one of its owners is down, and Set and Remove write to all of them.
+	// If blank, it defaults to 1.
+	Replication int
+
 	// Transport optionally specifies an http.RoundTripper for the client
 	// to use when it makes a request.
 	// If nil, the client uses http.DefaultTransport.
@@ -72,6 +128,26 @@ type HTTPPoolOptions struct {
 	// receives a request.
 	// If nil, uses the http.Request.Context()
 	Context func(*http.Request) context.Context
+
+	// HealthCheck optionally enables the ejection of unhealthy peers from
+	// the consistent hash. By default a peer is healthy if it answers HTTP
+	// requests.
+	HealthCheck *HealthCheckOptions
+
+	// Retry optionally retries requests to peers which fail to reach them.
+	Retry *RetryOptions
+
+	// Breaker optionally enables a circuit breaker for each peer, which
+	// fails requests fast while the peer is unreachable.
+	Breaker *BreakerOptions
+
+	// Timeout optionally limits each attempt of a request to a peer, in
+	// addition to the deadline of the caller's context.
+	Timeout time.Duration
+
+	// Handoff optionally pushes cached values to their new owners when
+	// the peers change, so that they are not loaded again.
+	Handoff *HandoffOptions
 }
 
 // NewHTTPPool initializes an HTTP pool of peers, and registers itself as a PeerPicker.
@@ -94,10 +170,18 @@ func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 		panic("groupcache: NewHTTPPool must be called only once")
 	}
 	httpPoolMade = true
+	return DefaultRegistry.NewHTTPPoolOpts(self, o)
+}
 
+// NewHTTPPoolOpts initializes an HTTP pool of peers with the given options
+// and registers it as the registry's PeerPicker. The pool serves the groups
+// of the registry. It must be registered as an HTTP handler using
+// http.Handle, or any other ServeMux, at the pool's BasePath.
+func (r *Registry) NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	p := &HTTPPool{
-		self:        self,
-		httpGetters: make(map[string]*httpGetter),
+		self:     self,
+		registry: r,
+		ejected:  make(map[string]bool),
 	}
 	if o != nil {
 		p.opts = *o
@@ -108,37 +192,396 @@ func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	if p.opts.Replicas == 0 {
 		p.opts.Replicas = defaultReplicas
 	}
-	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	var ring consistenthash.Placement
+	if p.opts.Placement != nil {
+		ring = p.opts.Placement()
+	} else {
+		ring = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	}
+	p.peers.Store(&httpPeers{
+		ring:    ring,
+		members: make(map[string]bool),
+		getters: make(map[string]*httpGetter),
+	})
+	if p.opts.HealthCheck != nil {
+		p.health = newHealthChecker(*p.opts.HealthCheck, p.probe, p.setEjected)
+	}
+	if p.opts.Retry != nil {
+		retry := *p.opts.Retry
+		if retry.MaxAttempts <= 0 {
+			retry.MaxAttempts = defaultRetryAttempts
+		}
+		if retry.MinBackoff <= 0 {
+			retry.MinBackoff = defaultRetryMinBackoff
+		}
+		if retry.MaxBackoff < retry.MinBackoff {
+			retry.MaxBackoff = defaultRetryMaxBackoff
+			if retry.MaxBackoff < retry.MinBackoff {
+				retry.MaxBackoff = retry.MinBackoff
+			}
+		}
+		p.opts.Retry = &retry
+	}
+	if p.opts.Handoff != nil {
+		handoff := *p.opts.Handoff
+		if handoff.KeysPerSecond <= 0 {
+			handoff.KeysPerSecond = defaultHandoffKeysPerSecond
+		}
+		if handoff.Timeout <= 0 {
+			handoff.Timeout = defaultHandoffTimeout
+		}
+		p.opts.Handoff = &handoff
+	}
 
-	RegisterPeerPicker(func() PeerPicker { return p })
+	r.RegisterPeerPicker(func() PeerPicker { return p })
 	return p
 }
 
 // Set updates the pool's list of peers.
 // Each peer value should be a valid base URL,
 // for example "http://example.net:8000".
+// Only the peers which were added or removed are hashed again.
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	p.all = peers
+	p.weights = nil
+	p.infos = nil
+	p.publish()
+}
+
+// SetWeighted updates the pool's list of peers, with the weight of each
+// peer. Peers get shares of the keys proportional to their weights, for
+// example to their memory, unless HTTPPoolOptions.Placement is not a
+// consistenthash.WeightedPlacement. A weight below 1 counts as 1.
+func (p *HTTPPool) SetWeighted(peers map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = make([]string, 0, len(peers))
+	p.weights = make(map[string]int)
+	for peer, w := range peers {
+		p.all = append(p.all, peer)
+		if w > 1 {
+			p.weights[peer] = w
+		}
+	}
+	sort.Strings(p.all)
+	p.infos = nil
+	p.publish()
+}
+
+// SetPeers updates the pool's list of peers with their descriptions,
+// which are returned by Peers, Self and PeerInfo. The zones of the peers
+// are used by the groups of the pool, see TopologyPeerPicker, and their
+// weights as with SetWeighted.
+func (p *HTTPPool) SetPeers(peers ...PeerInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = make([]string, 0, len(peers))
+	p.weights = make(map[string]int)
+	p.infos = make(map[string]PeerInfo, len(peers))
+	for _, info := range peers {
+		if _, dup := p.infos[info.URL]; !dup {
+			p.all = append(p.all, info.URL)
+		}
+		p.infos[info.URL] = info
+		if info.Weight > 1 {
+			p.weights[info.URL] = info.Weight
+		}
+	}
+	p.publish()
+}
+
+// AddPeers adds peers to the pool, if they are not in it already, with a
+// weight of 1.
+func (p *HTTPPool) AddPeers(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := append([]string(nil), p.all...)
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{
-			getTransport: p.opts.Transport,
-			baseURL:      peer + p.opts.BasePath,
+		if !containsString(all, peer) {
+			all = append(all, peer)
 		}
 	}
+	p.all = all
+	p.publish()
 }
 
-// GetAll returns all the peers in the pool
-func (p *HTTPPool) GetAll() []ProtoGetter {
+// RemovePeers removes peers from the pool.
+func (p *HTTPPool) RemovePeers(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var all []string
+	for _, peer := range p.all {
+		if !containsString(peers, peer) {
+			all = append(all, peer)
+		}
+	}
+	for _, peer := range peers {
+		delete(p.weights, peer)
+		delete(p.infos, peer)
+	}
+	p.all = all
+	p.publish()
+}
+
+// load returns the current peers of the pool.
+func (p *HTTPPool) load() *httpPeers {
+	return p.peers.Load().(*httpPeers)
+}
+
+// publish replaces the peers of the pool with p.all, leaving out ejected
+// peers from the consistent hash. The getters of the peers which remain
+// are kept, and the consistent hash is copied and updated with the peers
+// which were added or removed, or whose weight changed. p.mu must be held.
+func (p *HTTPPool) publish() {
+	prev := p.load()
+	next := &httpPeers{
+		members: make(map[string]bool, len(p.all)),
+		getters: make(map[string]*httpGetter, len(p.all)),
+		infos:   make(map[string]PeerInfo, len(p.all)),
+	}
+	var others []string
+	for _, peer := range p.all {
+		h, ok := prev.getters[peer]
+		if !ok {
+			h = p.newGetter(peer)
+		}
+		next.getters[peer] = h
+		info := p.infos[peer]
+		info.URL = peer
+		info.Weight = 1
+		if w, ok := p.weights[peer]; ok {
+			info.Weight = w
+		}
+		next.infos[peer] = info
+		if !p.ejected[peer] {
+			next.members[peer] = true
+			if w, ok := p.weights[peer]; ok {
+				if next.weights == nil {
+					next.weights = make(map[string]int)
+				}
+				next.weights[peer] = w
+			}
+		}
+		if peer != p.self {
+			others = append(others, peer)
+		}
+	}
+	for peer := range p.ejected {
+		if _, ok := next.getters[peer]; !ok {
+			delete(p.ejected, peer)
+		}
+	}
+
+	weighted, _ := prev.ring.(consistenthash.WeightedPlacement)
+	var added, removed []string
+	for peer := range next.members {
+		if !prev.members[peer] || (weighted != nil && prev.weight(peer) != next.weight(peer)) {
+			added = append(added, peer)
+		}
+	}
+	for peer := range prev.members {
+		if !next.members[peer] {
+			removed = append(removed, peer)
+		}
+	}
+	next.ring = prev.ring
+	if len(added) > 0 || len(removed) > 0 {
+		sort.Strings(added)
+		next.ring = prev.ring.Clone()
+		next.ring.Remove(removed...)
+		if ring, ok := next.ring.(consistenthash.WeightedPlacement); ok {
+			// new peers of weight 1 are added at once, which is faster
+			var plain []string
+			for _, peer := range added {
+				if w := next.weight(peer); w != 1 || prev.members[peer] {
+					ring.AddWeighted(peer, w)
+				} else {
+					plain = append(plain, peer)
+				}
+			}
+			ring.Add(plain...)
+		} else {
+			next.ring.Add(added...)
+		}
+	}
+	p.peers.Store(next)
+
+	if p.health != nil {
+		p.health.setPeers(others)
+	}
+	if p.opts.Handoff != nil && next.ring != prev.ring {
+		p.startHandoff(prev.ring, next)
+	}
+}
+
+func (p *HTTPPool) newGetter(peer string) *httpGetter {
+	h := &httpGetter{
+		getTransport: p.opts.Transport,
+		baseURL:      peer + p.opts.BasePath,
+		peer:         peer,
+		health:       p.health,
+		retry:        p.opts.Retry,
+		timeout:      p.opts.Timeout,
+	}
+	if p.opts.Breaker != nil || p.opts.Retry != nil {
+		h.breaker = newBreaker(p.opts.Breaker)
+	}
+	return h
+}
+
+// startHandoff stops the handoff in progress, if any, and hands off the
+// values whose keys moved since the last completed handoff. p.mu must be
+// held.
+func (p *HTTPPool) startHandoff(prev consistenthash.Placement, next *httpPeers) {
+	if p.closed {
+		return
+	}
+	if p.handoffCancel != nil {
+		// The values it did not hand off yet are handed off from
+		// the same consistent hash by the new handoff.
+		p.handoffCancel()
+	}
+	if p.handoffFrom == nil {
+		p.handoffFrom = prev
+	}
+	from := p.handoffFrom
+	if from.IsEmpty() {
+		// A new pool has nothing cached
+		p.handoffFrom = next.ring
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.handoffCancel, p.handoffDone = cancel, done
+	go func() {
+		defer close(done)
+		p.handoff(ctx, from, next)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if ctx.Err() == nil {
+			p.handoffFrom = next.ring
+			p.handoffCancel = nil
+		}
+		cancel()
+	}()
+}
+
+// Close stops the background work of the pool: the health checks of its
+// peers and the handoff in progress. The pool still picks peers and
+// serves requests, but peers are no longer ejected and values are no
+// longer handed off.
+func (p *HTTPPool) Close() error {
+	if p.health != nil {
+		p.health.close()
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.closed = true
+	if p.handoffCancel != nil {
+		p.handoffCancel()
+	}
+	return nil
+}
 
+// setEjected ejects peer from the consistent hash, or adds it back.
+func (p *HTTPPool) setEjected(peer string, ejected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.load().getters[peer]; !ok {
+		// No longer in the pool
+		return
+	}
+	if ejected {
+		p.ejected[peer] = true
+	} else {
+		delete(p.ejected, peer)
+	}
+	p.publish()
+}
+
+// probe is the default health check of peers, which succeeds if the peer
+// answers an HTTP request.
+func (p *HTTPPool) probe(ctx context.Context, peer string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+p.opts.BasePath, nil)
+	if err != nil {
+		return err
+	}
+	tr := http.DefaultTransport
+	if p.opts.Transport != nil {
+		tr = p.opts.Transport(ctx)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// BreakerStats returns the state of the circuit breaker and the retry
+// counts of each peer, keyed by the URL of the peer as in Group.PeerStats.
+// It returns nil unless the pool has Breaker or Retry options.
+func (p *HTTPPool) BreakerStats() map[string]BreakerStats {
+	getters := p.load().getters
+	var res map[string]BreakerStats
+	for _, h := range getters {
+		if h.breaker == nil {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]BreakerStats, len(getters))
+		}
+		res[h.GetURL()] = h.breaker.snapshot()
+	}
+	return res
+}
+
+// Peers returns the descriptions of the peers of the pool, in the order
+// they were given.
+func (p *HTTPPool) Peers() []PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := p.load()
+	res := make([]PeerInfo, 0, len(p.all))
+	for _, peer := range p.all {
+		if info, ok := peers.infos[peer]; ok {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+// Self returns the description of this process, see TopologyPeerPicker.
+func (p *HTTPPool) Self() PeerInfo {
+	if info, ok := p.load().infos[p.self]; ok {
+		return info
+	}
+	return PeerInfo{URL: p.self, Weight: 1}
+}
+
+// PeerInfo returns the description of a peer returned by the pool, see
+// TopologyPeerPicker.
+func (p *HTTPPool) PeerInfo(peer ProtoGetter) PeerInfo {
+	h, ok := peer.(*httpGetter)
+	if !ok {
+		return PeerInfo{}
+	}
+	if info, ok := p.load().infos[h.peer]; ok {
+		return info
+	}
+	return PeerInfo{URL: h.peer, Weight: 1}
+}
+
+// GetAll returns all the peers in the pool
+func (p *HTTPPool) GetAll() []ProtoGetter {
+	getters := p.load().getters
 	var i int
-	res := make([]ProtoGetter, len(p.httpGetters))
-	for _, v := range p.httpGetters {
+	res := make([]ProtoGetter, len(getters))
+	for _, v := range getters {
 		res[i] = v
 		i++
 	}
@@ -146,17 +589,51 @@ func (p *HTTPPool) GetAll() []ProtoGetter {
 }
 
 func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if p.peers.IsEmpty() {
+	peers := p.load()
+	if peers.ring.IsEmpty() {
 		return nil, false
 	}
-	if peer := p.peers.Get(key); peer != p.self {
-		return p.httpGetters[peer], true
+	if peer := peers.ring.Get(key); peer != p.self {
+		return peers.getters[peer], true
 	}
 	return nil, false
 }
 
+// PickPeers returns the owners of key, see ReplicatedPeerPicker.
+func (p *HTTPPool) PickPeers(key string) []ProtoGetter {
+	peers := p.load()
+	if peers.ring.IsEmpty() {
+		return []ProtoGetter{nil}
+	}
+	n := p.opts.Replication
+	if n < 1 {
+		n = 1
+	}
+	owners := peers.ring.GetN(key, n)
+	res := make([]ProtoGetter, len(owners))
+	for i, peer := range owners {
+		if peer != p.self {
+			res[i] = peers.getters[peer]
+		}
+	}
+	return res
+}
+
+// PickSuccessor returns the peer which follows the owners of key on the
+// consistent hash, see SuccessorPeerPicker.
+func (p *HTTPPool) PickSuccessor(key string) (ProtoGetter, bool) {
+	peers := p.load()
+	n := p.opts.Replication
+	if n < 1 {
+		n = 1
+	}
+	successors := peers.ring.GetN(key, n+1)
+	if len(successors) <= n || successors[n] == p.self {
+		return nil, false
+	}
+	return peers.getters[successors[n]], true
+}
+
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse request.
 	if !strings.HasPrefix(r.URL.Path, p.opts.BasePath) {
@@ -165,14 +642,15 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	parts := strings.SplitN(r.URL.Path[len(p.opts.BasePath):], "/", 2)
 	lenParts := len(parts)
 
-	if (lenParts != 2) || ((lenParts == 1) && (r.Method != http.MethodDelete)) {
+	// A POST to the group path is a batch get of several keys.
+	if (lenParts != 2) && (r.Method != http.MethodPost) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	groupName := parts[0]
 
 	// Fetch the value for this group/key.
-	group := GetGroup(groupName)
+	group := p.registry.GetGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
@@ -184,6 +662,17 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ctx = r.Context()
 	}
 
+	// Continue the trace of the peer which sent the request
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := group.startSpan(ctx, "groupcache.ServeHTTP",
+		attribute.String("http.method", r.Method))
+	defer span.End()
+
+	if r.Method == http.MethodPost {
+		p.serveGetMany(ctx, w, r, group)
+		return
+	}
+
 	group.Stats.ServerRequests.Add(1)
 
 	if (lenParts == 1) && (r.Method == http.MethodDelete) {
@@ -222,23 +711,71 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var b []byte
+	out, err := getResponse(ctx, group, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	value := AllocatingByteSliceSink(&b)
-	err := group.Get(ctx, key, value)
+	// Write the value to the response body as a proto message.
+	body, err := proto.Marshal(out)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(body)
+}
+
+// getResponse gets key from group on behalf of a peer, along with the
+// rate of requests from peers for key. Cacheable errors are returned in
+// the response. A panic of the getter is returned as an error, rather
+// than aborting the request as if the peer were unreachable.
+func getResponse(ctx context.Context, group *Group, key string) (_ *pb.GetResponse, err error) {
+	defer recoverTo(&err)
+	qps := group.rates.add(key, group.timer.Now())
+	var b []byte
+	value := AllocatingByteSliceSink(&b)
+	err = group.Get(ctx, key, value)
+	if err != nil {
+		if res, ok := errorResponse(err); ok {
+			res.MinuteQps = qps
+			return res, nil
+		}
+		return nil, err
+	}
 
 	view, err := value.view()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetResponse{Value: b, Expire: view.e, MinuteQps: qps}, nil
+}
+
+// serveGetMany answers a batch get request for several keys in group.
+func (p *HTTPPool) serveGetMany(ctx context.Context, w http.ResponseWriter, r *http.Request, group *Group) {
+	defer r.Body.Close()
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufferPool.Put(b)
+	_, err := io.Copy(b, r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.GetResponse{Value: b, Expire: view.e})
+	var in pb.GetManyRequest
+	err = proto.Unmarshal(b.Bytes(), &in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	group.Stats.ServerRequests.Add(int64(len(in.Keys)))
+	out := group.serveGetMany(ctx, in.Keys)
+
+	// Write the values to the response body as a proto message.
+	body, err := proto.Marshal(out)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -247,9 +784,73 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// writeError writes err to the response body as a GetResponse which
+// carries the error's status, so peers can tell errors apart.
+func writeError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	body, merr := proto.Marshal(&pb.GetResponse{Status: code, Error: err.Error()})
+	if merr != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusInternalServerError
+	switch code {
+	case pb.Status_NOT_FOUND:
+		status = http.StatusNotFound
+	case pb.Status_DEADLINE_EXCEEDED:
+		status = http.StatusGatewayTimeout
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// statusError is returned for an unsuccessful response to a Set, Remove or
+// Clear request.
+type statusError struct {
+	code int
+	body []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s", e.code, e.body)
+}
+
+// checkStatus returns a statusError if res is not successful.
+func checkStatus(res *http.Response) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("while reading body response: %v", res.Status)
+	}
+	return &statusError{code: res.StatusCode, body: body}
+}
+
+// readError returns the error in the body of an unsuccessful response.
+func readError(res *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024*1024)) // Limit reading the error body to max 1 MiB
+	if res.Header.Get("Content-Type") == "application/x-protobuf" {
+		var out pb.GetResponse
+		if err := proto.Unmarshal(msg, &out); err == nil && out.Status != pb.Status_OK {
+			return &PeerError{Code: out.Status, Message: out.Error}
+		}
+	}
+	return &PeerError{
+		Code:    pb.Status_INTERNAL,
+		Message: fmt.Sprintf("server returned: %v, %v", res.Status, string(msg)),
+	}
+}
+
 type httpGetter struct {
 	getTransport func(context.Context) http.RoundTripper
 	baseURL      string
+	peer         string         // as given to HTTPPool.Set
+	health       *healthChecker // if not nil, told whether requests reach the peer
+	breaker      *breaker       // if not nil, guards and counts requests
+	retry        *RetryOptions  // if not nil, retries failed requests
+	timeout      time.Duration  // if not zero, limits each attempt
 }
 
 func (p *httpGetter) GetURL() string {
@@ -285,6 +886,7 @@ func (h *httpGetter) makeRequest(ctx context.Context, m string, in request, b io
 	if err != nil {
 		return err
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	tr := http.DefaultTransport
 	if h.getTransport != nil {
@@ -300,15 +902,39 @@ func (h *httpGetter) makeRequest(ctx context.Context, m string, in request, b io
 }
 
 func (h *httpGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
-	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodGet, in, nil, &res); err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024*1024)) // Limit reading the error body to max 1 MiB
-		return fmt.Errorf("server returned: %v, %v", res.Status, string(msg))
+	return h.do(ctx, true, func(ctx context.Context) error {
+		var res http.Response
+		if err := h.makeRequest(ctx, http.MethodGet, in, nil, &res); err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return readError(&res)
+		}
+		return readBody(&res, out)
+	})
+}
+
+func (h *httpGetter) GetMany(ctx context.Context, in *pb.GetManyRequest, out *pb.GetManyResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("while marshaling GetManyRequest body: %w", err)
 	}
+	return h.do(ctx, true, func(ctx context.Context) error {
+		var res http.Response
+		if err := h.makeRequest(ctx, http.MethodPost, &pb.GetRequest{Group: in.GetGroup()}, bytes.NewReader(body), &res); err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return readError(&res)
+		}
+		return readBody(&res, out)
+	})
+}
+
+// readBody decodes the body of a successful response into out.
+func readBody(res *http.Response, out proto.Message) error {
 	b := bufferPool.Get().(*bytes.Buffer)
 	b.Reset()
 	defer bufferPool.Put(b)
@@ -328,52 +954,34 @@ func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
 	if err != nil {
 		return fmt.Errorf("while marshaling SetRequest body: %w", err)
 	}
-	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodPut, in, bytes.NewReader(body), &res); err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return fmt.Errorf("while reading body response: %v", res.Status)
+	return h.do(ctx, false, func(ctx context.Context) error {
+		var res http.Response
+		if err := h.makeRequest(ctx, http.MethodPut, in, bytes.NewReader(body), &res); err != nil {
+			return err
 		}
-		return fmt.Errorf("server returned status %d: %s", res.StatusCode, body)
-	}
-	return nil
+		defer res.Body.Close()
+		return checkStatus(&res)
+	})
 }
 
 func (h *httpGetter) Remove(ctx context.Context, in *pb.GetRequest) error {
-	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodDelete, in, nil, &res); err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return fmt.Errorf("while reading body response: %v", res.Status)
+	return h.do(ctx, true, func(ctx context.Context) error {
+		var res http.Response
+		if err := h.makeRequest(ctx, http.MethodDelete, in, nil, &res); err != nil {
+			return err
 		}
-		return fmt.Errorf("server returned status %d: %s", res.StatusCode, body)
-	}
-	return nil
+		defer res.Body.Close()
+		return checkStatus(&res)
+	})
 }
 
 func (h *httpGetter) Clear(ctx context.Context, in *pb.GetRequest) error {
-	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodDelete, in, nil, &res); err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return fmt.Errorf("while reading body response: %v", res.Status)
+	return h.do(ctx, true, func(ctx context.Context) error {
+		var res http.Response
+		if err := h.makeRequest(ctx, http.MethodDelete, in, nil, &res); err != nil {
+			return err
 		}
-		return fmt.Errorf("server returned status %d: %s", res.StatusCode, body)
-	}
-	return nil
+		defer res.Body.Close()
+		return checkStatus(&res)
+	})
 }
@@ -28,13 +28,17 @@ import (
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/mailgun/groupcache/v2/consistenthash"
+	pb "github.com/mailgun/groupcache/v2/groupcachepb"
 	"github.com/mailgun/groupcache/v2/timer"
+	"github.com/segmentio/fasthash/fnv1a"
 )
 
 var (
@@ -126,6 +130,27 @@ func TestHTTPPool(t *testing.T) {
 	}
 	serverHits = 0
 
+	// Fetch the same keys plus some new ones in batches, only
+	// the new keys should reach the server
+	keys := testKeys(nGets + 10)
+	results := g.GetMany(ctx, keys, func(string) Sink {
+		return StringSink(new(string))
+	})
+	for _, res := range results {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		value, _ := res.Dest.view()
+		if suffix := ":" + res.Key; !strings.HasSuffix(value.String(), suffix) {
+			t.Errorf("GetMany(%q) = %q, want value ending in %q", res.Key, value, suffix)
+		}
+	}
+
+	if serverHits != 10 {
+		t.Errorf("expected serverHits to be '10' got '%d'", serverHits)
+	}
+	serverHits = 0
+
 	var value string
 	key := "removeTestKey"
 
@@ -253,3 +278,812 @@ func awaitAddrReady(t *testing.T, addr string, wg *sync.WaitGroup) {
 		time.Sleep(delay)
 	}
 }
+
+func TestHTTPPoolPeerErrors(t *testing.T) {
+	newGroup("httpPeerErrorsTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		switch key {
+		case "missing":
+			return fmt.Errorf("looking up %q: %w", key, ErrNotFound)
+		case "slow":
+			return context.DeadlineExceeded
+		}
+		return errors.New("getter failed")
+	}), NoPeers{}, timer.Default{})
+
+	pool := &HTTPPool{opts: HTTPPoolOptions{BasePath: defaultBasePath}, registry: DefaultRegistry}
+	ts := httptest.NewServer(pool)
+	defer ts.Close()
+	peer := &httpGetter{baseURL: ts.URL + defaultBasePath}
+
+	tests := []struct {
+		key    string
+		code   pb.Status
+		target error
+	}{
+		{"missing", pb.Status_NOT_FOUND, ErrNotFound},
+		{"slow", pb.Status_DEADLINE_EXCEEDED, context.DeadlineExceeded},
+		{"broken", pb.Status_UNKNOWN, nil},
+	}
+	for _, tt := range tests {
+		var res pb.GetResponse
+		err := peer.Get(context.Background(), &pb.GetRequest{Group: "httpPeerErrorsTest", Key: tt.key}, &res)
+		var pe *PeerError
+		if !errors.As(err, &pe) {
+			t.Fatalf("Get(%q) error = %v, want a PeerError", tt.key, err)
+		}
+		if pe.Code != tt.code {
+			t.Errorf("Get(%q) code = %v, want %v", tt.key, pe.Code, tt.code)
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("Get(%q) error = %v, want it to match %v", tt.key, err, tt.target)
+		}
+	}
+
+	// Errors not sent by groupcache are reported as internal errors
+	var res pb.GetResponse
+	err := peer.Get(context.Background(), &pb.GetRequest{Group: "no-such-group", Key: "key"}, &res)
+	var pe *PeerError
+	if !errors.As(err, &pe) || pe.Code != pb.Status_INTERNAL {
+		t.Errorf("Get() on an unknown group error = %v, want an internal PeerError", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("expected an unknown group not to match ErrNotFound")
+	}
+}
+
+func TestRegistryHTTPPools(t *testing.T) {
+	const nNodes = 3
+	var (
+		registries [nNodes]*Registry
+		pools      [nNodes]*HTTPPool
+		groups     [nNodes]*Group
+		urls       []string
+	)
+	for i := range registries {
+		ts := httptest.NewUnstartedServer(nil)
+		defer ts.Close()
+		url := "http://" + ts.Listener.Addr().String()
+		urls = append(urls, url)
+
+		i := i
+		registries[i] = NewRegistry()
+		pools[i] = registries[i].NewHTTPPoolOpts(url, &HTTPPoolOptions{
+			// spreads the short test keys more evenly than the default
+			HashFn: fnv1a.HashBytes64,
+		})
+		groups[i] = registries[i].NewGroup("registryTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+			return dest.SetString(strconv.Itoa(i)+":"+key, 0)
+		}), timer.Default{})
+
+		mux := http.NewServeMux()
+		mux.Handle(defaultBasePath, pools[i])
+		ts.Config.Handler = mux
+		ts.Start()
+	}
+	for _, p := range pools {
+		p.Set(urls...)
+	}
+	if GetGroup("registryTest") != nil {
+		t.Fatal("expected groups of a Registry not to be in DefaultRegistry")
+	}
+
+	// Every node loads the keys it owns, whichever node is asked for them
+	owners := make(map[string]bool)
+	for _, key := range testKeys(10000) {
+		if len(owners) == nNodes {
+			break
+		}
+		var want string
+		for i, g := range groups {
+			var value string
+			if err := g.Get(context.Background(), key, StringSink(&value)); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				want = value
+			} else if value != want {
+				t.Fatalf("Get(%q) from node %d = %q, want %q", key, i, value, want)
+			}
+		}
+		owner, got, _ := strings.Cut(want, ":")
+		if got != key {
+			t.Errorf("Get(%q) = %q", key, want)
+		}
+		owners[owner] = true
+	}
+	if len(owners) != nNodes {
+		t.Errorf("expected keys to be loaded by %d nodes, got %v", nNodes, owners)
+	}
+	for i, g := range groups {
+		if g.Stats.ServerRequests.Get() == 0 {
+			t.Errorf("expected node %d to serve requests", i)
+		}
+	}
+}
+
+// flakyTransport fails the requests it is told to fail, and counts them.
+type flakyTransport struct {
+	mu       sync.Mutex
+	fail     int  // number of requests left to fail
+	hang     bool // block requests until their context is done
+	requests int
+}
+
+func TestRegistryHooks(t *testing.T) {
+	r := NewRegistry()
+	var pool *HTTPPool
+	r.RegisterServerStart(func() {
+		// the server start hook may create the pool of the registry
+		pool = r.NewHTTPPoolOpts("http://self", nil)
+	})
+	var hooked []string
+	r.AddNewGroupHook(func(g *Group) {
+		if r.GetGroup(g.Name()) != g {
+			t.Errorf("GetGroup(%q) from a hook did not return the new group", g.Name())
+		}
+		hooked = append(hooked, g.Name())
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.NewGroup("hooksTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+			return dest.SetString(key, 0)
+		}), timer.Default{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewGroup deadlocked running the registry hooks")
+	}
+	if pool == nil {
+		t.Error("expected the server start hook to run")
+	}
+	if len(hooked) != 1 || hooked[0] != "hooksTest" {
+		t.Errorf("hooks ran for %v, want [hooksTest]", hooked)
+	}
+}
+
+func (f *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests++
+	fail, hang := f.fail > 0, f.hang
+	if fail {
+		f.fail--
+	}
+	f.mu.Unlock()
+	if hang {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}
+	if fail {
+		return nil, errors.New("connection refused")
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (f *flakyTransport) set(fail int, hang bool) {
+	f.mu.Lock()
+	f.fail, f.hang, f.requests = fail, hang, 0
+	f.mu.Unlock()
+}
+
+func (f *flakyTransport) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// newResilienceTest returns a getter for a peer serving group "resilience"
+// through the pool made with opts.
+func newResilienceTest(t *testing.T, opts HTTPPoolOptions) (*HTTPPool, *httpGetter, *flakyTransport) {
+	server := NewRegistry()
+	server.NewGroup("resilience", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		if key == "panic" {
+			panic("getter panicked")
+		}
+		return dest.SetString("value:"+key, 0)
+	}), timer.Default{})
+	ts := httptest.NewServer(server.NewHTTPPoolOpts("", nil))
+	t.Cleanup(ts.Close)
+
+	tr := &flakyTransport{}
+	opts.Transport = func(context.Context) http.RoundTripper { return tr }
+	p := NewRegistry().NewHTTPPoolOpts("http://self", &opts)
+	p.Set(ts.URL)
+	return p, p.load().getters[ts.URL], tr
+}
+
+func TestHTTPRetry(t *testing.T) {
+	p, peer, tr := newResilienceTest(t, HTTPPoolOptions{
+		Retry: &RetryOptions{MaxAttempts: 3, MinBackoff: time.Millisecond},
+	})
+	ctx := context.Background()
+	get := func(group string) error {
+		var res pb.GetResponse
+		return peer.Get(ctx, &pb.GetRequest{Group: group, Key: "key"}, &res)
+	}
+
+	tr.set(2, false)
+	if err := get("resilience"); err != nil {
+		t.Fatalf("Get() after 2 failures = %v, want success on the third attempt", err)
+	}
+	if got := p.BreakerStats()[peer.GetURL()].Retries; got != 2 {
+		t.Errorf("Retries = %d, want 2", got)
+	}
+
+	tr.set(5, false)
+	if err := get("resilience"); err == nil {
+		t.Fatal("expected Get() to fail after 3 attempts")
+	}
+	if got := tr.count(); got != 3 {
+		t.Errorf("made %d attempts, want 3", got)
+	}
+
+	// Errors returned by the peer are not retried
+	tr.set(0, false)
+	if err := get("no-such-group"); err == nil {
+		t.Fatal("expected Get() of an unknown group to fail")
+	}
+	if got := tr.count(); got != 1 {
+		t.Errorf("made %d attempts for a peer error, want 1", got)
+	}
+
+	// Set is not retried
+	tr.set(1, false)
+	if err := peer.Set(ctx, &pb.SetRequest{Group: "resilience", Key: "key", Value: []byte("value")}); err == nil {
+		t.Fatal("expected Set() to fail")
+	}
+	if got := tr.count(); got != 1 {
+		t.Errorf("made %d attempts for Set, want 1", got)
+	}
+
+	// Backoffs stop when the caller's context is done
+	tr.set(5, false)
+	p.opts.Retry.MinBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := get("resilience"); err == nil {
+		t.Fatal("expected Get() to fail")
+	}
+	if d := time.Since(start); d > time.Minute {
+		t.Errorf("Get() returned after %v, want it to stop with its context", d)
+	}
+}
+
+func TestHTTPGetterPanic(t *testing.T) {
+	_, peer, tr := newResilienceTest(t, HTTPPoolOptions{
+		Retry: &RetryOptions{MaxAttempts: 3, MinBackoff: time.Millisecond},
+	})
+	ctx := context.Background()
+
+	// A panic of the owner's getter is an internal error, which is not
+	// retried as if the owner were unreachable
+	var res pb.GetResponse
+	err := peer.Get(ctx, &pb.GetRequest{Group: "resilience", Key: "panic"}, &res)
+	var pe *PeerError
+	if !errors.As(err, &pe) || pe.Code != pb.Status_INTERNAL {
+		t.Errorf("Get() error = %v, want an internal PeerError", err)
+	}
+	if got := tr.count(); got != 1 {
+		t.Errorf("made %d attempts, want 1", got)
+	}
+
+	var many pb.GetManyResponse
+	in := &pb.GetManyRequest{Group: "resilience", Keys: []string{"key", "panic"}}
+	if err := peer.GetMany(ctx, in, &many); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range many.Results {
+		if r.Key == "panic" && r.Status != pb.Status_INTERNAL {
+			t.Errorf("GetMany(%q) status = %v, want %v", r.Key, r.Status, pb.Status_INTERNAL)
+		}
+	}
+}
+
+func TestHTTPCircuitBreaker(t *testing.T) {
+	fake := timer.NewFake(time.Now())
+	p, peer, tr := newResilienceTest(t, HTTPPoolOptions{
+		Breaker: &BreakerOptions{MaxFailures: 2, OpenTimeout: 10 * time.Second, Timer: fake},
+		Timeout: 10 * time.Millisecond,
+	})
+	get := func() error {
+		var res pb.GetResponse
+		return peer.Get(context.Background(), &pb.GetRequest{Group: "resilience", Key: "key"}, &res)
+	}
+	state := func() BreakerState {
+		return p.BreakerStats()[peer.GetURL()].State
+	}
+
+	// Two requests which time out open the breaker
+	tr.set(0, true)
+	for i := 0; i < 2; i++ {
+		if err := get(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Get() = %v, want a deadline exceeded error", err)
+		}
+	}
+	if state() != BreakerOpen {
+		t.Fatalf("breaker is %v, want open", state())
+	}
+
+	// While open, requests fail without being sent
+	tr.set(0, false)
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get() = %v, want ErrCircuitOpen", err)
+	}
+	if tr.count() != 0 {
+		t.Error("expected the request not to be sent while the breaker is open")
+	}
+
+	// A failed trial opens it again
+	fake.Advance(10 * time.Second)
+	tr.set(1, false)
+	if err := get(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get() = %v, want the trial to fail", err)
+	}
+	if state() != BreakerOpen {
+		t.Fatalf("breaker is %v after a failed trial, want open", state())
+	}
+
+	// A trial abandoned by its caller leaves it open
+	fake.Advance(10 * time.Second)
+	tr.set(0, true)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	var res pb.GetResponse
+	err := peer.Get(ctx, &pb.GetRequest{Group: "resilience", Key: "key"}, &res)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() = %v, want a deadline exceeded error", err)
+	}
+	if s := p.BreakerStats()[peer.GetURL()]; s.State != BreakerOpen || s.Failures != 3 {
+		t.Fatalf("stats = %+v after an abandoned trial, want open with 3 failures", s)
+	}
+
+	// A successful trial closes it
+	tr.set(0, false)
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	s := p.BreakerStats()[peer.GetURL()]
+	if s.State != BreakerClosed || s.Opens != 2 || s.Rejected != 1 {
+		t.Errorf("stats = %+v, want closed after 2 opens and 1 rejection", s)
+	}
+
+	// The breaker survives updates of the peer list
+	p.Set(p.all...)
+	if p.BreakerStats()[peer.GetURL()].Opens != 2 {
+		t.Error("expected the breaker to be kept by Set")
+	}
+}
+
+func TestHTTPPoolHandoff(t *testing.T) {
+	const nNodes = 3
+	var (
+		pools  [nNodes]*HTTPPool
+		groups [nNodes]*Group
+		loads  [nNodes]AtomicInt
+		urls   []string
+	)
+	for i := range pools {
+		ts := httptest.NewUnstartedServer(nil)
+		defer ts.Close()
+		urls = append(urls, "http://"+ts.Listener.Addr().String())
+
+		i := i
+		r := NewRegistry()
+		pools[i] = r.NewHTTPPoolOpts(urls[i], &HTTPPoolOptions{
+			HashFn:  fnv1a.HashBytes64,
+			Handoff: &HandoffOptions{KeysPerSecond: 1e6},
+		})
+		groups[i] = r.NewGroup("handoffTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+			loads[i].Add(1)
+			return dest.SetString("value:"+key, 0)
+		}), timer.Default{})
+
+		mux := http.NewServeMux()
+		mux.Handle(defaultBasePath, pools[i])
+		ts.Config.Handler = mux
+		ts.Start()
+	}
+	get := func(i int, key string) {
+		t.Helper()
+		var value string
+		if err := groups[i].Get(context.Background(), key, StringSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+		if value != "value:"+key {
+			t.Fatalf("Get(%q) = %q", key, value)
+		}
+	}
+	waitHandoff := func() {
+		for _, p := range pools {
+			p.mu.Lock()
+			done := p.handoffDone
+			p.mu.Unlock()
+			if done != nil {
+				<-done
+			}
+		}
+	}
+
+	// Pick keys of which some move to node 2 once it joins, however the
+	// ports of the nodes spread the keys.
+	ring := consistenthash.New(defaultReplicas, fnv1a.HashBytes64)
+	ring.Add(urls...)
+	var keys []string
+	var nMoving int
+	for _, key := range testKeys(10000) {
+		if ring.Get(key) != urls[2] {
+			if len(keys)-nMoving < 50 {
+				keys = append(keys, key)
+			}
+		} else if nMoving < 50 {
+			keys = append(keys, key)
+			nMoving++
+		}
+	}
+
+	// Nodes 0 and 1 load the keys they own
+	for _, p := range pools {
+		p.Set(urls[:2]...)
+	}
+	for _, key := range keys {
+		get(0, key)
+	}
+
+	// Node 2 joins, and is handed the values of the keys it now owns
+	for _, p := range pools {
+		p.Set(urls...)
+	}
+	waitHandoff()
+	var moved []string
+	for _, key := range keys {
+		if _, remote := pools[2].PickPeer(key); !remote {
+			moved = append(moved, key)
+		}
+	}
+	if len(moved) == 0 {
+		t.Fatal("expected node 2 to own some keys")
+	}
+	handedOff := groups[0].Stats.HandoffKeys.Get() + groups[1].Stats.HandoffKeys.Get()
+	if handedOff != int64(len(moved)) {
+		t.Errorf("handed off %d values, want %d", handedOff, len(moved))
+	}
+	if n := groups[2].CacheStats(MainCache).Items; n != int64(len(moved)) {
+		t.Errorf("node 2 caches %d values, want %d", n, len(moved))
+	}
+	if n := groups[0].CacheStats(MainCache).Items + groups[1].CacheStats(MainCache).Items; n != int64(len(keys)-len(moved)) {
+		t.Errorf("nodes 0 and 1 cache %d values, want %d", n, len(keys)-len(moved))
+	}
+	for _, key := range moved {
+		get(2, key)
+	}
+	if n := loads[2].Get(); n != 0 {
+		t.Errorf("node 2 loaded %d values handed off to it", n)
+	}
+}
+
+func TestHTTPPoolHandoffFailure(t *testing.T) {
+	const nNodes = 2
+	var (
+		pools  [nNodes]*HTTPPool
+		groups [nNodes]*Group
+		urls   []string
+	)
+	for i := range pools {
+		ts := httptest.NewUnstartedServer(nil)
+		defer ts.Close()
+		urls = append(urls, "http://"+ts.Listener.Addr().String())
+
+		r := NewRegistry()
+		pools[i] = r.NewHTTPPoolOpts(urls[i], &HTTPPoolOptions{
+			HashFn:  fnv1a.HashBytes64,
+			Handoff: &HandoffOptions{KeysPerSecond: 1e6},
+		})
+		groups[i] = r.NewGroup("handoffFailureTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+			return dest.SetString("value:"+key, 0)
+		}), timer.Default{})
+
+		mux := http.NewServeMux()
+		mux.Handle(defaultBasePath, pools[i])
+		ts.Config.Handler = mux
+		ts.Start()
+	}
+	// The joining node is unreachable, so every push to it fails
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	all := append(append([]string(nil), urls...), dead.URL)
+
+	// Pick keys of which some move to the new node, however the ports of
+	// the nodes spread the keys.
+	ring := consistenthash.New(defaultReplicas, fnv1a.HashBytes64)
+	ring.Add(all...)
+	var keys []string
+	var moved int
+	for _, key := range testKeys(10000) {
+		if ring.Get(key) != dead.URL {
+			if len(keys)-moved < 50 {
+				keys = append(keys, key)
+			}
+		} else if moved < 50 {
+			keys = append(keys, key)
+			moved++
+		}
+	}
+
+	for _, p := range pools {
+		p.Set(urls...)
+	}
+	for _, key := range keys {
+		var value string
+		if err := groups[0].Get(context.Background(), key, StringSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range pools {
+		p.Set(all...)
+	}
+	for _, p := range pools {
+		p.mu.Lock()
+		done := p.handoffDone
+		p.mu.Unlock()
+		if done != nil {
+			<-done
+		}
+	}
+	if n := groups[0].Stats.HandoffErrs.Get() + groups[1].Stats.HandoffErrs.Get(); n != int64(moved) {
+		t.Errorf("%d failed pushes, want %d", n, moved)
+	}
+	if n := groups[0].CacheStats(MainCache).Items + groups[1].CacheStats(MainCache).Items; n != int64(len(keys)-moved) {
+		t.Errorf("nodes 0 and 1 cache %d values, want %d", n, len(keys)-moved)
+	}
+}
+
+func TestHTTPPoolMembership(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c", "http://d"}
+	newPool := func() *HTTPPool {
+		return NewRegistry().NewHTTPPoolOpts("http://a", nil)
+	}
+	owners := func(p *HTTPPool) map[string]string {
+		res := make(map[string]string)
+		for _, key := range testKeys(1000) {
+			if peer, ok := p.PickPeer(key); ok {
+				res[key] = peer.GetURL()
+			}
+		}
+		return res
+	}
+	want := newPool()
+	want.Set(peers[:3]...)
+
+	// Adding and removing peers gives the same consistent hash as Set
+	p := newPool()
+	p.AddPeers(peers[:2]...)
+	p.AddPeers(peers[1:]...)
+	getter := p.load().getters["http://b"]
+	p.RemovePeers("http://d", "http://unknown")
+	if !reflect.DeepEqual(owners(p), owners(want)) {
+		t.Error("AddPeers and RemovePeers disagree with Set")
+	}
+	if p.load().getters["http://b"] != getter {
+		t.Error("expected the getters of remaining peers to be kept")
+	}
+	p.Set(peers[1:3]...)
+	p.Set(peers[:3]...)
+	if !reflect.DeepEqual(owners(p), owners(want)) {
+		t.Error("Set after Set disagrees with Set")
+	}
+
+	// Picking peers does not wait for updates
+	p.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		p.PickPeer("key")
+		p.GetAll()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("PickPeer waited for the pool's lock")
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p.RemovePeers("http://c")
+			p.AddPeers("http://c")
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		p.PickPeers(fmt.Sprint(i))
+	}
+	wg.Wait()
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	p := NewRegistry().NewHTTPPoolOpts("http://a", &HTTPPoolOptions{
+		Placement:   func() consistenthash.Placement { return consistenthash.NewRendezvous(nil) },
+		Replication: 2,
+	})
+	p.AddPeers(peers[:2]...)
+	p.AddPeers(peers[2])
+
+	// getter returns the getter of peer, or nil for this process
+	getters := p.load().getters
+	getter := func(peer string) ProtoGetter {
+		if peer == "http://a" {
+			return nil
+		}
+		return getters[peer]
+	}
+	want := consistenthash.NewRendezvous(nil)
+	want.Add(peers...)
+	for _, key := range testKeys(100) {
+		owners := want.GetN(key, 2)
+		peer, ok := p.PickPeer(key)
+		if ok != (owners[0] != "http://a") || (ok && peer != getter(owners[0])) {
+			t.Errorf("PickPeer(%q) did not pick %q", key, owners[0])
+		}
+		picked := p.PickPeers(key)
+		if len(picked) != 2 || picked[0] != getter(owners[0]) || picked[1] != getter(owners[1]) {
+			t.Errorf("PickPeers(%q) did not pick %v", key, owners)
+		}
+	}
+}
+
+func TestHTTPPoolWeighted(t *testing.T) {
+	p := NewRegistry().NewHTTPPoolOpts("http://a", &HTTPPoolOptions{Replicas: 100, HashFn: fnv1a.HashBytes64})
+	owners := func() map[string]string {
+		res := make(map[string]string)
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("user/%d/profile", i)
+			res[key] = "http://a"
+			if peer, ok := p.PickPeer(key); ok {
+				res[key] = peer.(*httpGetter).peer
+			}
+		}
+		return res
+	}
+	shares := func(owners map[string]string) map[string]float64 {
+		res := make(map[string]float64)
+		for _, peer := range owners {
+			res[peer] += 1 / float64(len(owners))
+		}
+		return res
+	}
+
+	p.SetWeighted(map[string]int{"http://a": 1, "http://b": 1, "http://c": 2})
+	before := owners()
+	s := shares(before)
+	if s["http://c"] < 0.4 || s["http://c"] > 0.6 {
+		t.Errorf("peer of weight 2 owns %.2f of the keys, want about 0.5", s["http://c"])
+	}
+
+	// Raising a weight only moves keys to the peer
+	p.SetWeighted(map[string]int{"http://a": 1, "http://b": 1, "http://c": 6})
+	after := owners()
+	for key, peer := range after {
+		if peer != before[key] && peer != "http://c" {
+			t.Fatalf("key %q moved from %q to %q", key, before[key], peer)
+		}
+	}
+	s = shares(after)
+	if s["http://c"] < 0.65 || s["http://c"] > 0.85 {
+		t.Errorf("peer of weight 6 owns %.2f of the keys, want about 0.75", s["http://c"])
+	}
+
+	// Set resets the weights
+	p.Set("http://a", "http://b", "http://c")
+	want := NewRegistry().NewHTTPPoolOpts("http://a", &HTTPPoolOptions{Replicas: 100, HashFn: fnv1a.HashBytes64})
+	want.Set("http://a", "http://b", "http://c")
+	got := owners()
+	p = want
+	if !reflect.DeepEqual(got, owners()) {
+		t.Error("Set after SetWeighted disagrees with Set")
+	}
+}
+
+func TestHTTPPoolSetPeers(t *testing.T) {
+	p := NewRegistry().NewHTTPPoolOpts("http://a", nil)
+	p.SetPeers(
+		PeerInfo{URL: "http://a", Zone: "us-east-1a"},
+		PeerInfo{URL: "http://b", Zone: "us-east-1b", Weight: 2},
+		PeerInfo{URL: "http://c", Zone: "us-east-1a", Labels: map[string]string{"rack": "r7"}},
+	)
+	if got := p.Self(); got.Zone != "us-east-1a" {
+		t.Errorf("Self() = %+v, want zone us-east-1a", got)
+	}
+	peers := p.Peers()
+	if len(peers) != 3 || peers[1].Weight != 2 || peers[2].Weight != 1 || peers[2].Labels["rack"] != "r7" {
+		t.Errorf("Peers() = %+v", peers)
+	}
+	for _, peer := range p.GetAll() {
+		info := p.PeerInfo(peer)
+		if info.URL != peer.(*httpGetter).peer || info.Zone == "" {
+			t.Errorf("PeerInfo(%q) = %+v", peer.GetURL(), info)
+		}
+	}
+	if w := p.load().weight("http://b"); w != 2 {
+		t.Errorf("http://b has weight %d on the ring, want 2", w)
+	}
+
+	// The descriptions of removed peers are dropped, and Set drops them all
+	p.RemovePeers("http://c")
+	p.AddPeers("http://c")
+	if got := p.Peers()[2]; got.Zone != "" || got.Labels != nil {
+		t.Errorf("peer added back has description %+v, want none", got)
+	}
+	p.Set("http://a", "http://b")
+	if got := p.Peers(); !reflect.DeepEqual(got, []PeerInfo{{URL: "http://a", Weight: 1}, {URL: "http://b", Weight: 1}}) {
+		t.Errorf("Peers() after Set = %+v", got)
+	}
+}
+
+func TestHTTPHealthCallerDeadline(t *testing.T) {
+	p, peer, tr := newResilienceTest(t, HTTPPoolOptions{
+		HealthCheck: &HealthCheckOptions{MaxFailures: 1, Timer: timer.NewFake(time.Now())},
+		Timeout:     20 * time.Millisecond,
+	})
+	get := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		var res pb.GetResponse
+		return peer.Get(ctx, &pb.GetRequest{Group: "resilience", Key: "key"}, &res)
+	}
+	ejected := func() bool {
+		_, remote := p.PickPeer("key")
+		return !remote
+	}
+
+	// The caller's own deadline passing says nothing about the peer
+	tr.set(0, true)
+	if err := get(time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if ejected() {
+		t.Fatal("peer was ejected after the caller's deadline passed")
+	}
+
+	// The timeout of the attempt does
+	if err := get(time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !ejected() {
+		t.Error("peer was not ejected after the attempt timed out")
+	}
+}
+
+func TestHTTPPoolClose(t *testing.T) {
+	fake := timer.NewFake(time.Now())
+	var probes AtomicInt
+	p := NewRegistry().NewHTTPPoolOpts("http://a", &HTTPPoolOptions{
+		HealthCheck: &HealthCheckOptions{
+			Interval: time.Second,
+			Timer:    fake,
+			Probe: func(ctx context.Context, peer string) error {
+				probes.Add(1)
+				return errors.New("down")
+			},
+		},
+	})
+	p.Set("http://a", "http://b")
+
+	fake.Advance(time.Second)
+	if n := probes.Get(); n != 1 {
+		t.Fatalf("peer probed %d times, want 1", n)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(time.Minute)
+	if n := probes.Get(); n != 1 {
+		t.Errorf("peer probed %d times after Close, want 1", n)
+	}
+}
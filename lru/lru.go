@@ -72,6 +72,7 @@ func (c *Cache) Add(key Key, value interface{}, expire int64) {
 		}
 		c.ll.MoveToFront(ee)
 		eee.value = value
+		eee.expire = expire
 		return
 	}
 	ele := c.ll.PushFront(&entry{key, value, expire})
@@ -121,6 +122,30 @@ func (c *Cache) RemoveOldest() {
 	}
 }
 
+// RemoveExpired examines at most n items, in no particular order, and
+// removes those which have expired. It returns the number of items removed.
+func (c *Cache) RemoveExpired(n int) (removed int) {
+	now := int64(-1)
+	for _, ele := range c.cache {
+		if n <= 0 {
+			break
+		}
+		n--
+		e := ele.Value.(*entry)
+		if e.expire == 0 {
+			continue
+		}
+		if now < 0 {
+			now = c.timer.Now()
+		}
+		if e.expire < now {
+			c.removeElement(ele)
+			removed++
+		}
+	}
+	return removed
+}
+
 func (c *Cache) removeElement(e *list.Element) {
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
@@ -130,6 +155,25 @@ func (c *Cache) removeElement(e *list.Element) {
 	}
 }
 
+// Range calls fn for each item which has not expired, from the most to the
+// least recently used, until fn returns false. It does not update the
+// recency of the items. fn must not modify the cache.
+func (c *Cache) Range(fn func(key Key, value interface{}) bool) {
+	if c.cache == nil {
+		return
+	}
+	now := c.timer.Now()
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		e := ele.Value.(*entry)
+		if e.expire != 0 && e.expire < now {
+			continue
+		}
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	if c.cache == nil {
@@ -78,6 +78,16 @@ func TestAdd_evictsOldAndReplaces(t *testing.T) {
 	}
 }
 
+func TestAdd_replacesExpire(t *testing.T) {
+	lru := New(0, timer.Default{})
+	lru.Add("myKey", 1234, time.Now().Add(-time.Second).UnixNano())
+	lru.Add("myKey", 1235, 0)
+
+	if _, ok := lru.Get("myKey"); !ok {
+		t.Fatalf("%s: replaced entry kept the expire time of the old entry", t.Name())
+	}
+}
+
 func TestGet(t *testing.T) {
 	for _, tt := range getTests {
 		lru := New(0, timer.Default{})
@@ -142,9 +152,10 @@ func TestExpire(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		lru := New(0, timer.Default{})
-		lru.Add(tt.key, 1234, time.Now().Add(tt.expire).UnixNano())
-		time.Sleep(tt.wait)
+		clock := timer.NewFake(time.Now())
+		lru := New(0, clock)
+		lru.Add(tt.key, 1234, clock.Now()+int64(tt.expire))
+		clock.Advance(tt.wait)
 		val, ok := lru.Get(tt.key)
 		if ok != tt.expectedOk {
 			t.Fatalf("%s: cache hit = %v; want %v", tt.name, ok, !ok)
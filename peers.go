@@ -34,6 +34,13 @@ type ProtoGetter interface {
 	GetURL() string
 }
 
+// BatchProtoGetter is an optional interface a ProtoGetter may implement
+// to fetch several keys in a single round trip. Group.GetMany falls back
+// to one Get per key for peers that don't implement it.
+type BatchProtoGetter interface {
+	GetMany(context context.Context, in *pb.GetManyRequest, out *pb.GetManyResponse) error
+}
+
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
 type PeerPicker interface {
@@ -45,23 +52,77 @@ type PeerPicker interface {
 	GetAll() []ProtoGetter
 }
 
+// ReplicatedPeerPicker is an optional interface a PeerPicker may implement
+// to store each key on several owners. Loads try the owners in order,
+// and Set and Remove write to all of them.
+type ReplicatedPeerPicker interface {
+	PeerPicker
+
+	// PickPeers returns the owners of key, in the order in which they
+	// are tried. The current peer is returned as a nil ProtoGetter.
+	PickPeers(key string) []ProtoGetter
+}
+
+// SuccessorPeerPicker is an optional interface a PeerPicker may implement
+// to name the peer which follows the owners of a key on its ring. Hedged
+// requests are sent to it when the owner of a key is slow to answer.
+type SuccessorPeerPicker interface {
+	PeerPicker
+
+	// PickSuccessor returns the peer which follows the owners of key
+	// and true to indicate that a remote peer was nominated.
+	// It returns nil, false if it is the current peer or if there is
+	// no peer other than the owners.
+	PickSuccessor(key string) (peer ProtoGetter, ok bool)
+}
+
+// PeerInfo describes a peer, beyond the URL it is reached at.
+type PeerInfo struct {
+	// URL is the base URL of the peer, for example
+	// "http://10.0.0.2:8008".
+	URL string
+
+	// Zone is the zone of the peer, such as an availability zone or a
+	// rack. Peers in the same zone as the current peer are preferred for
+	// loads, see TopologyPeerPicker. If empty, the zone is unknown.
+	Zone string
+
+	// Weight is the weight of the peer on the consistent hash, see
+	// HTTPPool.SetWeighted. If zero, it is 1.
+	Weight int
+
+	// Labels optionally hold other metadata of the peer.
+	Labels map[string]string
+}
+
+// TopologyPeerPicker is an optional interface a PeerPicker may implement
+// to describe its peers, such as their zones. When the current peer has a
+// zone, loads try the owners of a key in its zone before the owners in
+// other zones, and values fetched from other zones are admitted to the
+// hot cache more readily, see HotCacheOptions.CrossZoneFactor.
+type TopologyPeerPicker interface {
+	PeerPicker
+
+	// Self returns the description of the current peer.
+	Self() PeerInfo
+
+	// PeerInfo returns the description of peer, as returned by the
+	// picker.
+	PeerInfo(peer ProtoGetter) PeerInfo
+}
+
 // NoPeers is an implementation of PeerPicker that never finds a peer.
 type NoPeers struct{}
 
 func (NoPeers) PickPeer(key string) (peer ProtoGetter, ok bool) { return }
 func (NoPeers) GetAll() []ProtoGetter                           { return []ProtoGetter{} }
 
-var portPicker func(groupName string) PeerPicker
-
 // RegisterPeerPicker registers the peer initialization function.
 // It is called once, when the first group is created.
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 func RegisterPeerPicker(fn func() PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-	portPicker = func(_ string) PeerPicker { return fn() }
+	DefaultRegistry.RegisterPeerPicker(fn)
 }
 
 // RegisterPerGroupPeerPicker registers the peer initialization function,
@@ -70,19 +131,5 @@ func RegisterPeerPicker(fn func() PeerPicker) {
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 func RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-	portPicker = fn
-}
-
-func getPeers(groupName string) PeerPicker {
-	if portPicker == nil {
-		return NoPeers{}
-	}
-	pk := portPicker(groupName)
-	if pk == nil {
-		pk = NoPeers{}
-	}
-	return pk
+	DefaultRegistry.RegisterPerGroupPeerPicker(fn)
 }
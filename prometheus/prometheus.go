@@ -0,0 +1,211 @@
+// Package prometheus exports the statistics of groupcache groups as
+// Prometheus metrics.
+//
+//	prometheus.MustRegister(groupcacheprom.NewCollector(groupcache.DefaultRegistry))
+package prometheus
+
+import (
+	"sync"
+
+	"github.com/mailgun/groupcache/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "groupcache"
+
+// statsCounters describes the counters exported for Group.Stats.
+var statsCounters = []struct {
+	name  string
+	help  string
+	value func(*groupcache.Stats) int64
+}{
+	{"gets_total", "Get requests, including from peers.", func(s *groupcache.Stats) int64 { return s.Gets.Get() }},
+	{"cache_hits_total", "Get requests served from the main or hot cache.", func(s *groupcache.Stats) int64 { return s.CacheHits.Get() }},
+	{"peer_loads_total", "Values loaded from peers.", func(s *groupcache.Stats) int64 { return s.PeerLoads.Get() }},
+	{"peer_errors_total", "Failed loads from peers.", func(s *groupcache.Stats) int64 { return s.PeerErrors.Get() }},
+	{"loads_total", "Get requests which missed the cache.", func(s *groupcache.Stats) int64 { return s.Loads.Get() }},
+	{"loads_deduped_total", "Loads after deduplication of concurrent requests.", func(s *groupcache.Stats) int64 { return s.LoadsDeduped.Get() }},
+	{"local_loads_total", "Values loaded by the group's Getter.", func(s *groupcache.Stats) int64 { return s.LocalLoads.Get() }},
+	{"local_load_errors_total", "Failed loads by the group's Getter.", func(s *groupcache.Stats) int64 { return s.LocalLoadErrs.Get() }},
+	{"server_requests_total", "Get requests received from peers.", func(s *groupcache.Stats) int64 { return s.ServerRequests.Get() }},
+	{"stale_hits_total", "Cache hits on stale values.", func(s *groupcache.Stats) int64 { return s.StaleHits.Get() }},
+	{"refreshes_total", "Background refreshes of stale values.", func(s *groupcache.Stats) int64 { return s.Refreshes.Get() }},
+	{"refresh_errors_total", "Background refreshes of stale values which failed.", func(s *groupcache.Stats) int64 { return s.RefreshErrs.Get() }},
+	{"hedges_fired_total", "Hedged requests sent when an owner was slow to answer.", func(s *groupcache.Stats) int64 { return s.HedgesFired.Get() }},
+	{"hedges_won_total", "Hedged requests which answered before the owner.", func(s *groupcache.Stats) int64 { return s.HedgesWon.Get() }},
+	{"handoff_keys_total", "Values pushed to their new owner when the peers changed.", func(s *groupcache.Stats) int64 { return s.HandoffKeys.Get() }},
+	{"handoff_bytes_total", "Bytes of the values pushed to their new owner when the peers changed.", func(s *groupcache.Stats) int64 { return s.HandoffBytes.Get() }},
+	{"handoff_errors_total", "Values which failed to be pushed to their new owner.", func(s *groupcache.Stats) int64 { return s.HandoffErrs.Get() }},
+}
+
+// cacheGauges describes the gauges exported for Group.CacheStats.
+var cacheGauges = []struct {
+	name  string
+	help  string
+	value func(groupcache.CacheStats) int64
+}{
+	{"cache_bytes", "Size of the keys and values in the cache.", func(s groupcache.CacheStats) int64 { return s.Bytes }},
+	{"cache_items", "Number of items in the cache.", func(s groupcache.CacheStats) int64 { return s.Items }},
+	{"cache_evictions", "Number of items evicted from the cache.", func(s groupcache.CacheStats) int64 { return s.Evictions }},
+	{"cache_expirations", "Number of expired items removed from the cache.", func(s groupcache.CacheStats) int64 { return s.Expirations }},
+}
+
+// latencyHistograms describes the histograms exported for the latency
+// stats in Group.Stats.
+var latencyHistograms = []struct {
+	name  string
+	help  string
+	value func(*groupcache.Stats) *groupcache.Histogram
+}{
+	{"peer_get_latency_seconds", "Latency of requests to peers for values.", func(s *groupcache.Stats) *groupcache.Histogram { return &s.PeerGetLatency }},
+	{"peer_set_latency_seconds", "Latency of Set requests to peers.", func(s *groupcache.Stats) *groupcache.Histogram { return &s.PeerSetLatency }},
+	{"peer_remove_latency_seconds", "Latency of Remove requests to peers.", func(s *groupcache.Stats) *groupcache.Histogram { return &s.PeerRemoveLatency }},
+	{"local_load_latency_seconds", "Latency of calls to the group's Getter.", func(s *groupcache.Stats) *groupcache.Histogram { return &s.LocalLoadLatency }},
+}
+
+// peerHistograms describes the histograms exported for Group.PeerStats,
+// labelled by operation.
+var peerHistograms = []struct {
+	op    string
+	value func(*groupcache.PeerStats) *groupcache.Histogram
+}{
+	{"get", func(s *groupcache.PeerStats) *groupcache.Histogram { return &s.GetLatency }},
+	{"set", func(s *groupcache.PeerStats) *groupcache.Histogram { return &s.SetLatency }},
+	{"remove", func(s *groupcache.PeerStats) *groupcache.Histogram { return &s.RemoveLatency }},
+}
+
+var cacheTypes = []struct {
+	label string
+	which groupcache.CacheType
+}{
+	{"main", groupcache.MainCache},
+	{"hot", groupcache.HotCache},
+}
+
+// Collector is a prometheus.Collector for the groups of a
+// groupcache.Registry. Groups created after the collector are picked up
+// automatically, and deregistered groups are no longer exported.
+type Collector struct {
+	registry *groupcache.Registry
+
+	counters    []*prometheus.Desc
+	gauges      []*prometheus.Desc
+	histograms  []*prometheus.Desc
+	peerLatency *prometheus.Desc
+
+	mu     sync.Mutex
+	groups map[*groupcache.Group]struct{}
+}
+
+// NewCollector returns a collector for the groups of r, for example
+// groupcache.DefaultRegistry.
+func NewCollector(r *groupcache.Registry) *Collector {
+	c := &Collector{
+		registry: r,
+		peerLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "peer_latency_seconds"),
+			"Latency of requests to each peer.",
+			[]string{"group", "peer", "op"}, nil),
+		groups: make(map[*groupcache.Group]struct{}),
+	}
+	for _, s := range statsCounters {
+		c.counters = append(c.counters, prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", s.name), s.help, []string{"group"}, nil))
+	}
+	for _, s := range latencyHistograms {
+		c.histograms = append(c.histograms, prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", s.name), s.help, []string{"group"}, nil))
+	}
+	for _, s := range cacheGauges {
+		c.gauges = append(c.gauges, prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", s.name), s.help, []string{"group", "type"}, nil))
+	}
+
+	// Add the hook first so that no group is missed between the two.
+	r.AddNewGroupHook(c.addGroup)
+	for _, g := range r.Groups() {
+		c.addGroup(g)
+	}
+	return c
+}
+
+func (c *Collector) addGroup(g *groupcache.Group) {
+	c.mu.Lock()
+	c.groups[g] = struct{}{}
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.counters {
+		ch <- d
+	}
+	for _, d := range c.gauges {
+		ch <- d
+	}
+	for _, d := range c.histograms {
+		ch <- d
+	}
+	ch <- c.peerLatency
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	// The registry must not be locked while holding c.mu, since addGroup
+	// may be called by the registry.
+	c.mu.Lock()
+	all := make([]*groupcache.Group, 0, len(c.groups))
+	for g := range c.groups {
+		all = append(all, g)
+	}
+	c.mu.Unlock()
+
+	var groups, stale []*groupcache.Group
+	for _, g := range all {
+		if c.registry.GetGroup(g.Name()) != g {
+			stale = append(stale, g)
+			continue
+		}
+		groups = append(groups, g)
+	}
+	if len(stale) > 0 {
+		c.mu.Lock()
+		for _, g := range stale {
+			delete(c.groups, g)
+		}
+		c.mu.Unlock()
+	}
+
+	for _, g := range groups {
+		name := g.Name()
+		for i, s := range statsCounters {
+			ch <- prometheus.MustNewConstMetric(c.counters[i], prometheus.CounterValue, float64(s.value(&g.Stats)), name)
+		}
+		for i, s := range latencyHistograms {
+			ch <- constHistogram(c.histograms[i], s.value(&g.Stats), name)
+		}
+		for peer, stats := range g.PeerStats() {
+			for _, s := range peerHistograms {
+				ch <- constHistogram(c.peerLatency, s.value(stats), name, peer, s.op)
+			}
+		}
+		for _, t := range cacheTypes {
+			stats := g.CacheStats(t.which)
+			for i, s := range cacheGauges {
+				ch <- prometheus.MustNewConstMetric(c.gauges[i], prometheus.GaugeValue, float64(s.value(stats)), name, t.label)
+			}
+		}
+	}
+}
+
+// constHistogram converts h into a Prometheus histogram in seconds.
+func constHistogram(desc *prometheus.Desc, h *groupcache.Histogram, labels ...string) prometheus.Metric {
+	s := h.Snapshot()
+	buckets := make(map[float64]uint64, len(s.Bounds))
+	var count uint64
+	for i, bound := range s.Bounds {
+		count += uint64(s.Counts[i])
+		buckets[bound.Seconds()] = count
+	}
+	return prometheus.MustNewConstHistogram(desc, uint64(s.Count), s.Sum.Seconds(), buckets, labels...)
+}
@@ -0,0 +1,67 @@
+package prometheus
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/groupcache/v2"
+	"github.com/mailgun/groupcache/v2/timer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	r := groupcache.NewRegistry()
+	getter := groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+		return dest.SetString("value", 0)
+	})
+	before := r.NewGroup("before", 1<<20, getter, timer.Default{})
+
+	c := NewCollector(r)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	after := r.NewGroup("after", 1<<20, getter, timer.Default{})
+	for _, g := range []*groupcache.Group{before, after} {
+		for i := 0; i < 2; i++ {
+			var s string
+			if err := g.Get(context.Background(), "key", groupcache.StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	expected := `
+# HELP groupcache_cache_hits_total Get requests served from the main or hot cache.
+# TYPE groupcache_cache_hits_total counter
+groupcache_cache_hits_total{group="after"} 1
+groupcache_cache_hits_total{group="before"} 1
+# HELP groupcache_cache_items Number of items in the cache.
+# TYPE groupcache_cache_items gauge
+groupcache_cache_items{group="after",type="hot"} 0
+groupcache_cache_items{group="after",type="main"} 1
+groupcache_cache_items{group="before",type="hot"} 0
+groupcache_cache_items{group="before",type="main"} 1
+# HELP groupcache_gets_total Get requests, including from peers.
+# TYPE groupcache_gets_total counter
+groupcache_gets_total{group="after"} 2
+groupcache_gets_total{group="before"} 2
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"groupcache_cache_hits_total", "groupcache_cache_items", "groupcache_gets_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Latency histograms are exported in seconds
+	if n := testutil.CollectAndCount(c, "groupcache_local_load_latency_seconds"); n != 2 {
+		t.Errorf("expected 2 local load latency histograms, got %d", n)
+	}
+
+	// Deregistered groups are no longer exported
+	r.DeregisterGroup("before")
+	if n := testutil.CollectAndCount(c, "groupcache_gets_total"); n != 1 {
+		t.Errorf("expected 1 group to be exported, got %d", n)
+	}
+}
@@ -21,6 +21,8 @@ protoc -I=$PROTO_DIR \
     --go_opt=paths=source_relative \
     --fastmarshal_out=paths=source_relative:$PROTO_DIR \
     --fastmarshal_opt=apiversion=v2,enableunsafedecode=true \
+    --go-grpc_out=$PROTO_DIR \
+    --go-grpc_opt=paths=source_relative \
     $PROTO_DIR/groupcache.proto
 
 protoc -I=$PROTO_DIR \
@@ -0,0 +1,41 @@
+package groupcache
+
+import (
+	"sync"
+	"time"
+)
+
+// keyRates estimates the rate of requests for each key over the last
+// minute. Requests are counted for the current and the previous minute,
+// so only the keys requested in the last two minutes take memory.
+type keyRates struct {
+	mu    sync.Mutex
+	start int64 // start of the current minute
+	cur   map[string]int64
+	prev  map[string]int64
+}
+
+// add records a request for key at now, and returns the rate of requests
+// for key in queries per second over the last minute.
+func (r *keyRates) add(key string, now int64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	const minute = int64(time.Minute)
+	switch elapsed := now - r.start; {
+	case r.cur == nil || elapsed >= 2*minute:
+		r.start = now
+		r.cur = make(map[string]int64)
+		r.prev = nil
+	case elapsed >= minute:
+		r.start = now - elapsed%minute
+		r.prev = r.cur
+		r.cur = make(map[string]int64)
+	}
+	r.cur[key]++
+
+	// Count the part of the previous minute which is still within the
+	// last minute.
+	frac := float64(now-r.start) / float64(minute)
+	return (float64(r.prev[key])*(1-frac) + float64(r.cur[key])) / 60
+}
@@ -0,0 +1,222 @@
+package groupcache
+
+import (
+	"sync"
+
+	"github.com/mailgun/groupcache/v2/singleflight"
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+// A Registry holds a set of groups along with the peer picker they use
+// to locate the owners of keys, and is served to peers by the pools
+// created with it. Each Registry is an independent cluster, so several
+// of them can coexist in one process, for example to run a multi-node
+// cluster inside a single test.
+//
+// The package-level functions such as NewGroup, GetGroup and
+// RegisterPeerPicker operate on DefaultRegistry.
+type Registry struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+
+	portPicker func(groupName string) PeerPicker
+
+	// newGroupHook, if non-nil, is called right after a new group is created.
+	newGroupHook func(*Group)
+
+	// newGroupHooks are called right after a new group is created, after
+	// newGroupHook.
+	newGroupHooks []func(*Group)
+
+	initPeerServerOnce sync.Once
+	initPeerServer     func()
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// DefaultRegistry is the Registry used by the package-level functions.
+var DefaultRegistry = NewRegistry()
+
+// GetGroup returns the named group previously created with NewGroup, or
+// nil if there's no such group.
+func (r *Registry) GetGroup(name string) *Group {
+	r.mu.RLock()
+	g := r.groups[name]
+	r.mu.RUnlock()
+	return g
+}
+
+// NewGroup creates a coordinated group-aware Getter from a Getter in
+// the registry, see the package-level NewGroup.
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter, timer timer.Timer) *Group {
+	return r.NewGroupWithOptions(name, getter, GroupOptions{CacheBytes: cacheBytes, Timer: timer})
+}
+
+// Groups returns the groups of the registry, in no particular order.
+func (r *Registry) Groups() []*Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	groups := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// DeregisterGroup removes group from the registry and stops its expiry
+// sweeper, if any.
+func (r *Registry) DeregisterGroup(name string) {
+	r.mu.Lock()
+	g := r.groups[name]
+	delete(r.groups, name)
+	r.mu.Unlock()
+	if g != nil {
+		g.stopExpirySweep()
+	}
+}
+
+// NewGroupWithOptions creates a group in the registry, see the
+// package-level NewGroupWithOptions. If o.PeerPicker is nil, the peer
+// picker of the registry is initialized when the group is first used.
+func (r *Registry) NewGroupWithOptions(name string, getter Getter, o GroupOptions) *Group {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	if o.Timer == nil {
+		o.Timer = timer.Default{}
+	}
+	if o.HotCacheRatio <= 0 {
+		o.HotCacheRatio = defaultHotCacheRatio
+	}
+	// The server start hook and new group hooks run without holding r.mu,
+	// so they may create pools and register peer pickers.
+	r.initPeerServerOnce.Do(r.callInitPeerServer)
+	r.mu.Lock()
+	if _, dup := r.groups[name]; dup {
+		r.mu.Unlock()
+		panic("duplicate registration of group " + name)
+	}
+	g := &Group{
+		name:          name,
+		registry:      r,
+		getter:        getter,
+		peers:         o.PeerPicker,
+		timer:         o.Timer,
+		cacheBytes:    o.CacheBytes,
+		logger:        o.Logger,
+		hotCacheRatio: o.HotCacheRatio,
+		defaultTTL:    o.DefaultTTL,
+		maxValueSize:  o.MaxValueSize,
+		loadTimeout:   o.LoadTimeout,
+		hotCacheOpts:  o.HotCache,
+		hedge:         newHedger(o.Hedge),
+		refreshOpts:   o.Refresh,
+		negativeTTL:   o.NegativeTTL,
+		mainCache:     cache{timer: o.Timer, newPolicy: o.EvictionPolicy, nshards: o.CacheShards},
+		hotCache:      cache{timer: o.Timer, newPolicy: o.EvictionPolicy, nshards: o.CacheShards},
+		loadGroup:     &singleflight.Group{},
+		setGroup:      &singleflight.Group{},
+		removeGroup:   &singleflight.Group{},
+	}
+	g.SetExpirySweep(o.ExpirySweep)
+	r.groups[name] = g
+	hook, hooks := r.newGroupHook, r.newGroupHooks
+	r.mu.Unlock()
+
+	if hook != nil {
+		hook(g)
+	}
+	for _, fn := range hooks {
+		fn(g)
+	}
+	return g
+}
+
+// If peers is nil, the peerPicker is called via a sync.Once to initialize it.
+func (r *Registry) newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker, timer timer.Timer) *Group {
+	return r.NewGroupWithOptions(name, getter, GroupOptions{
+		CacheBytes: cacheBytes,
+		PeerPicker: peers,
+		Timer:      timer,
+	})
+}
+
+// RegisterPeerPicker registers the peer initialization function.
+// It is called once, when the first group is created.
+// Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
+// called exactly once, but not both.
+func (r *Registry) RegisterPeerPicker(fn func() PeerPicker) {
+	r.RegisterPerGroupPeerPicker(func(_ string) PeerPicker { return fn() })
+}
+
+// RegisterPerGroupPeerPicker registers the peer initialization function,
+// which takes the groupName, to be used in choosing a PeerPicker.
+// It is called once, when the first group is created.
+// Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
+// called exactly once, but not both.
+func (r *Registry) RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.portPicker != nil {
+		panic("RegisterPeerPicker called more than once")
+	}
+	r.portPicker = fn
+}
+
+func (r *Registry) getPeers(groupName string) PeerPicker {
+	r.mu.RLock()
+	portPicker := r.portPicker
+	r.mu.RUnlock()
+	if portPicker == nil {
+		return NoPeers{}
+	}
+	pk := portPicker(groupName)
+	if pk == nil {
+		pk = NoPeers{}
+	}
+	return pk
+}
+
+// RegisterNewGroupHook registers a hook that is run each time
+// a group is created.
+func (r *Registry) RegisterNewGroupHook(fn func(*Group)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.newGroupHook != nil {
+		panic("RegisterNewGroupHook called more than once")
+	}
+	r.newGroupHook = fn
+}
+
+// AddNewGroupHook adds a hook that is run each time a group is created.
+// Unlike RegisterNewGroupHook it may be called any number of times, for
+// example by several metrics integrations. Groups created before the
+// hook was added are not passed to it, see Groups.
+func (r *Registry) AddNewGroupHook(fn func(*Group)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.newGroupHooks = append(r.newGroupHooks, fn)
+}
+
+// RegisterServerStart registers a hook that is run when the first
+// group is created.
+func (r *Registry) RegisterServerStart(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.initPeerServer != nil {
+		panic("RegisterServerStart called more than once")
+	}
+	r.initPeerServer = fn
+}
+
+func (r *Registry) callInitPeerServer() {
+	r.mu.RLock()
+	fn := r.initPeerServer
+	r.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
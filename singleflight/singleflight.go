@@ -20,6 +20,7 @@ package singleflight
 
 import (
 	"fmt"
+	"runtime/debug"
 	"sync"
 )
 
@@ -58,7 +59,75 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	g.m[key] = c
 	g.mu.Unlock()
 
+	g.doCall(c, key, fn, false)
+	return c.val, c.err
+}
+
+// PanicError is the error of a flight whose function panicked, as
+// received from DoChan.
+type PanicError struct {
+	Value interface{} // passed to panic
+	Stack []byte      // of the goroutine which panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Result holds the results of Do, so they can be passed
+// on a channel.
+type Result struct {
+	Val interface{}
+	Err error
+}
+
+// DoChan is like Do but returns a channel that will receive the
+// results when they are ready. The returned bool reports whether
+// the caller is the leader of the flight, in which case fn is run
+// in its own goroutine. Otherwise fn is not called and the channel
+// receives the results of the flight already in progress for key.
+//
+// Unlike Do, DoChan never blocks, so callers can start flights for
+// several keys before waiting on any of them. If fn panics, the panic is
+// recovered and the flight fails with a *PanicError, since there is no
+// caller to propagate it to.
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) (<-chan Result, bool) {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		go func() {
+			c.wg.Wait()
+			ch <- Result{c.val, c.err}
+		}()
+		return ch, false
+	}
+	c := &call{
+		err: fmt.Errorf("singleflight leader panicked"),
+	}
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go func() {
+		g.doCall(c, key, fn, true)
+		ch <- Result{c.val, c.err}
+	}()
+	return ch, true
+}
+
+// doCall handles the single call for a key. If recoverPanic is set, a
+// panic of fn fails the call instead of unwinding the goroutine.
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error), recoverPanic bool) {
 	defer func() {
+		if recoverPanic {
+			if v := recover(); v != nil {
+				c.val, c.err = nil, &PanicError{Value: v, Stack: debug.Stack()}
+			}
+		}
 		c.wg.Done()
 
 		g.mu.Lock()
@@ -67,8 +136,6 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	}()
 
 	c.val, c.err = fn()
-
-	return c.val, c.err
 }
 
 // Lock prevents single flights from occurring for the duration
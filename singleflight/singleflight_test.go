@@ -149,3 +149,66 @@ func TestDoConcurrentPanic(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return <-c, nil
+	}
+
+	ch1, leader := g.DoChan("key", fn)
+	if !leader {
+		t.Errorf("first DoChan leader = false; want true")
+	}
+	ch2, leader := g.DoChan("key", fn)
+	if leader {
+		t.Errorf("second DoChan leader = true; want false")
+	}
+
+	c <- "bar"
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		res := <-ch
+		if res.Err != nil {
+			t.Errorf("DoChan error: %v", res.Err)
+		}
+		if res.Val.(string) != "bar" {
+			t.Errorf("got %q; want %q", res.Val, "bar")
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+}
+
+func TestDoChanPanic(t *testing.T) {
+	var g Group
+	c := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-c
+		panic("something went horribly wrong")
+	}
+
+	ch1, _ := g.DoChan("key", fn)
+	ch2, _ := g.DoChan("key", fn)
+	close(c)
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		res := <-ch
+		var pe *PanicError
+		if !errors.As(res.Err, &pe) || pe.Value != "something went horribly wrong" || len(pe.Stack) == 0 {
+			t.Errorf("DoChan error = %v; want a *PanicError with the panic value", res.Err)
+		}
+		if res.Val != nil {
+			t.Errorf("got %q; want nil", res.Val)
+		}
+	}
+
+	// ensure subsequent calls to same key still work
+	ch, _ := g.DoChan("key", func() (interface{}, error) { return "foo", nil })
+	res := <-ch
+	if res.Err != nil || res.Val.(string) != "foo" {
+		t.Errorf("DoChan after a panic = %v, %v; want foo", res.Val, res.Err)
+	}
+}
@@ -0,0 +1,95 @@
+package groupcache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+// defaultSweepEntries is the default ExpirySweepOptions.MaxEntries.
+const defaultSweepEntries = 1000
+
+// ExpirySweepOptions configures the background removal of expired
+// entries from the caches of a group.
+type ExpirySweepOptions struct {
+	// Interval is the time between sweeps, measured by the group's timer.
+	// If zero, expired entries are only removed when they are looked up
+	// or evicted.
+	Interval time.Duration
+
+	// MaxEntries is the number of entries examined by each sweep in each
+	// of the main and hot caches, which bounds the time the caches are
+	// locked. Entries are sampled in no particular order, so large caches
+	// take several sweeps to be fully reclaimed. If zero, it defaults
+	// to 1000.
+	MaxEntries int
+}
+
+// SetExpirySweep starts a background sweeper which periodically removes
+// expired entries, so that they stop counting against the group's cache
+// size before they are looked up or evicted. Removed entries are counted
+// in CacheStats.Expirations. Calling it again replaces the sweeper, and
+// a zero Interval stops it. The sweeper stops when the group is
+// deregistered.
+//
+// If the group's timer implements timer.Scheduler, like timer.Fake, the
+// sweeps are scheduled with it, otherwise with time.AfterFunc.
+func (g *Group) SetExpirySweep(o ExpirySweepOptions) {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = defaultSweepEntries
+	}
+	g.sweepMu.Lock()
+	defer g.sweepMu.Unlock()
+	if g.sweeper != nil {
+		g.sweeper.stop()
+		g.sweeper = nil
+	}
+	if o.Interval > 0 {
+		g.sweeper = &sweeper{g: g, opts: o}
+		g.sweeper.schedule()
+	}
+}
+
+func (g *Group) stopExpirySweep() {
+	g.sweepMu.Lock()
+	defer g.sweepMu.Unlock()
+	if g.sweeper != nil {
+		g.sweeper.stop()
+		g.sweeper = nil
+	}
+}
+
+// sweeper runs the sweeps of a group until it is stopped.
+type sweeper struct {
+	g    *Group
+	opts ExpirySweepOptions
+
+	mu       sync.Mutex
+	stopped  bool
+	stopNext func() // cancels the next sweep
+}
+
+func (s *sweeper) schedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopNext = timer.AfterFunc(s.g.timer, s.opts.Interval, s.sweep)
+}
+
+func (s *sweeper) sweep() {
+	s.g.mainCache.removeExpired(s.opts.MaxEntries)
+	s.g.hotCache.removeExpired(s.opts.MaxEntries)
+	s.schedule()
+}
+
+func (s *sweeper) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.stopNext != nil {
+		s.stopNext()
+	}
+}
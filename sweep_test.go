@@ -0,0 +1,80 @@
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/timer"
+)
+
+func TestExpirySweep(t *testing.T) {
+	clock := timer.NewFake(time.Now())
+	r := NewRegistry()
+	g := r.newGroup("TestExpirySweep-group", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		if key == "forever" {
+			return dest.SetString(key, 0)
+		}
+		return dest.SetBytes(make([]byte, 1000), clock.Now()+int64(time.Minute))
+	}), NoPeers{}, clock)
+	g.SetExpirySweep(ExpirySweepOptions{Interval: time.Second, MaxEntries: 64})
+
+	load := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			var b []byte
+			if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), AllocatingByteSliceSink(&b)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var s string
+	if err := g.Get(dummyCtx, "forever", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	load(100)
+
+	// Nothing has expired yet
+	clock.Advance(30 * time.Second)
+	if st := g.CacheStats(MainCache); st.Items != 101 || st.Expirations != 0 {
+		t.Fatalf("unexpected cache stats before expiry %+v", st)
+	}
+
+	// The expired entries are reclaimed within a few sweeps, without
+	// being looked up.
+	clock.Advance(time.Minute)
+	st := g.CacheStats(MainCache)
+	if st.Items != 1 || st.Bytes != int64(2*len("forever")) || st.Expirations != 100 || st.Evictions != 0 {
+		t.Fatalf("unexpected cache stats after expiry %+v", st)
+	}
+
+	// Deregistering the group stops the sweeper
+	r.DeregisterGroup("TestExpirySweep-group")
+	load(10)
+	clock.Advance(2 * time.Minute)
+	if st := g.CacheStats(MainCache); st.Items != 11 || st.Expirations != 100 {
+		t.Fatalf("unexpected cache stats after deregistration %+v", st)
+	}
+}
+
+func TestExpirySweepWallClock(t *testing.T) {
+	r := NewRegistry()
+	g := r.newGroup("TestExpirySweepWallClock-group", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString(key, time.Now().Add(time.Millisecond).UnixNano())
+	}), NoPeers{}, timer.Default{})
+	g.SetExpirySweep(ExpirySweepOptions{Interval: 5 * time.Millisecond})
+	defer r.DeregisterGroup("TestExpirySweepWallClock-group")
+
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for g.CacheStats(MainCache).Expirations != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired entry was not swept, stats %+v", g.CacheStats(MainCache))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
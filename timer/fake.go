@@ -0,0 +1,98 @@
+package timer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake timer only moves when it is advanced or set, so that tests of
+// expiry don't depend on the wall clock. It is safe for concurrent use.
+type Fake struct {
+	mu    sync.Mutex
+	now   int64
+	hooks []fakeHook // sorted by deadline
+}
+
+type fakeHook struct {
+	deadline int64
+	fn       func()
+}
+
+// NewFake creates a fake timer set to t.
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t.UnixNano()}
+}
+
+func (f *Fake) Now() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the timer forward by d, running the hooks whose deadline
+// is crossed.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	for {
+		h, ok := f.popDue(f.now + int64(d))
+		if !ok {
+			break
+		}
+		d -= time.Duration(h.deadline - f.now)
+		f.now = h.deadline
+		f.mu.Unlock()
+		h.fn()
+		f.mu.Lock()
+	}
+	f.now += int64(d)
+	f.mu.Unlock()
+}
+
+// Set sets the timer to t, running the hooks whose deadline is crossed
+// if t is after the current time.
+func (f *Fake) Set(t time.Time) {
+	to := t.UnixNano()
+	f.mu.Lock()
+	for {
+		h, ok := f.popDue(to)
+		if !ok {
+			break
+		}
+		f.now = h.deadline
+		f.mu.Unlock()
+		h.fn()
+		f.mu.Lock()
+	}
+	f.now = to
+	f.mu.Unlock()
+}
+
+// AfterFunc registers fn to be called once the timer reaches d after the
+// current time. Hooks run in order of their deadline, from the goroutine
+// which moves the timer, and see the timer set to their deadline. If d is
+// not positive, fn is called immediately.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) {
+	if d <= 0 {
+		fn()
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := fakeHook{deadline: f.now + int64(d), fn: fn}
+	i := sort.Search(len(f.hooks), func(i int) bool { return f.hooks[i].deadline > h.deadline })
+	f.hooks = append(f.hooks, fakeHook{})
+	copy(f.hooks[i+1:], f.hooks[i:])
+	f.hooks[i] = h
+}
+
+// popDue removes and returns the first hook if its deadline is not after
+// limit. f.mu must be held.
+func (f *Fake) popDue(limit int64) (fakeHook, bool) {
+	if len(f.hooks) == 0 || f.hooks[0].deadline > limit {
+		return fakeHook{}, false
+	}
+	h := f.hooks[0]
+	f.hooks = f.hooks[1:]
+	return h, true
+}
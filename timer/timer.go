@@ -12,6 +12,30 @@ type Timer interface {
 	Now() int64
 }
 
+// Scheduler is implemented by timers which can call a function once a
+// duration of their own time has passed, like Fake. Background work
+// driven by other timers is scheduled with time.AfterFunc.
+type Scheduler interface {
+	AfterFunc(d time.Duration, fn func())
+}
+
+// AfterFunc calls fn once d has passed on t, with t.AfterFunc if t is a
+// Scheduler, otherwise with time.AfterFunc. The returned function cancels
+// the call if fn has not started yet.
+func AfterFunc(t Timer, d time.Duration, fn func()) (stop func()) {
+	if s, ok := t.(Scheduler); ok {
+		var stopped int32
+		s.AfterFunc(d, func() {
+			if atomic.LoadInt32(&stopped) == 0 {
+				fn()
+			}
+		})
+		return func() { atomic.StoreInt32(&stopped, 1) }
+	}
+	tm := time.AfterFunc(d, fn)
+	return func() { tm.Stop() }
+}
+
 // Default timer reads Unix time always when requested
 type Default struct{}
 
@@ -1,10 +1,82 @@
 package timer
 
-import "time"
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 var (
 	_ Timer = Default{}
 	_ Timer = Fast{}
 	_ Timer = FastEpoch(time.Now().UnixNano())
 	_ Timer = NewCachedTimer(NanoTime, time.Second)
+	_ Timer = NewFake(time.Now())
 )
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	if got := f.Now(); got != start.UnixNano() {
+		t.Fatalf("Now() = %d, want %d", got, start.UnixNano())
+	}
+
+	var fired []int64
+	f.AfterFunc(2*time.Second, func() { fired = append(fired, f.Now()) })
+	f.AfterFunc(time.Second, func() { fired = append(fired, f.Now()) })
+	f.AfterFunc(5*time.Second, func() { fired = append(fired, f.Now()) })
+
+	f.Advance(500 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("expected no hooks to fire, fired at %v", fired)
+	}
+	f.Advance(2 * time.Second)
+	want := []int64{start.Add(time.Second).UnixNano(), start.Add(2 * time.Second).UnixNano()}
+	if !reflect.DeepEqual(fired, want) {
+		t.Errorf("hooks fired at %v, want %v", fired, want)
+	}
+	if got, want := f.Now(), start.Add(2500*time.Millisecond).UnixNano(); got != want {
+		t.Errorf("Now() = %d, want %d", got, want)
+	}
+
+	f.Set(start.Add(time.Minute))
+	if len(fired) != 3 || fired[2] != start.Add(5*time.Second).UnixNano() {
+		t.Errorf("expected the last hook to fire at its deadline, fired at %v", fired)
+	}
+	if got, want := f.Now(), start.Add(time.Minute).UnixNano(); got != want {
+		t.Errorf("Now() = %d, want %d", got, want)
+	}
+
+	// Setting the time backwards is allowed
+	f.Set(start)
+	if got := f.Now(); got != start.UnixNano() {
+		t.Errorf("Now() = %d, want %d", got, start.UnixNano())
+	}
+}
+
+func TestFakeConcurrentAdvance(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	var hooks int32
+	for i := 1; i <= 100; i++ {
+		f.AfterFunc(time.Duration(i)*time.Millisecond, func() { atomic.AddInt32(&hooks, 1) })
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				f.Advance(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := f.Now(), int64(time.Second); got != want {
+		t.Errorf("Now() = %d, want %d", got, want)
+	}
+	if hooks != 100 {
+		t.Errorf("expected 100 hooks to fire, got %d", hooks)
+	}
+}
@@ -0,0 +1,41 @@
+package groupcache
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer used for spans.
+// Spans are only recorded once an application sets the global tracer
+// provider, with otel.SetTracerProvider. Likewise trace context is only
+// propagated between peers once it sets the global propagator, with
+// otel.SetTextMapPropagator.
+const tracerName = "github.com/mailgun/groupcache/v2"
+
+// startSpan starts a span of an operation on group g.
+func (g *Group) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		// callers have been allowed to pass a nil context
+		ctx = context.Background()
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(
+		append(attrs, attribute.String("groupcache.group", g.name))...))
+}
+
+// endSpan ends span, recording err if it is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// peerAttr returns the span attribute which identifies peer.
+func peerAttr(peer ProtoGetter) attribute.KeyValue {
+	return attribute.String("groupcache.peer", peer.GetURL())
+}
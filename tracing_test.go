@@ -0,0 +1,68 @@
+package groupcache
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mailgun/groupcache/v2/timer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	const name = "TestTracing-group"
+	getter := GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("got:"+key, 0)
+	})
+
+	// The owner of every key serves the group from its own registry
+	r := NewRegistry()
+	r.newGroup(name, cacheSize, getter, NoPeers{}, timer.Default{})
+	ts := httptest.NewServer(&HTTPPool{opts: HTTPPoolOptions{BasePath: defaultBasePath}, registry: r})
+	defer ts.Close()
+
+	peer := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	g := newGroup(name, cacheSize, getter, fakePeers([]ProtoGetter{peer}), timer.Default{})
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"groupcache.Get", "groupcache.load", "groupcache.getFromPeer", "groupcache.ServeHTTP", "groupcache.getLocally"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("no %q span recorded, got %v", name, spans)
+		}
+	}
+
+	// The spans of both nodes are part of the same trace
+	traceID := spans["groupcache.Get"].SpanContext().TraceID()
+	for name, span := range spans {
+		if span.SpanContext().TraceID() != traceID {
+			t.Errorf("span %q is not part of the trace of the Get", name)
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Value.Emit() == "key" {
+				t.Errorf("span %q records the key as %s", name, attr.Key)
+			}
+		}
+	}
+	if got, want := spans["groupcache.ServeHTTP"].Parent().SpanID(), spans["groupcache.getFromPeer"].SpanContext().SpanID(); got != want {
+		t.Errorf("expected ServeHTTP span to be a child of the getFromPeer span")
+	}
+}